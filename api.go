@@ -1,39 +1,165 @@
 package dogdirect
 
 import (
-	"bytes"
-	"encoding/json"
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"net/url"
+	"strings"
 	"time"
 )
 
-var endpointv1 = "https://api.datadoghq.com/api/v1"
+// Datadog sites, for use with WithSite.  These match the values of
+// the agent's DD_SITE setting.
+const (
+	SiteUS1 = "datadoghq.com"
+	SiteUS3 = "us3.datadoghq.com"
+	SiteUS5 = "us5.datadoghq.com"
+	SiteEU1 = "datadoghq.eu"
+	SiteAP1 = "ap1.datadoghq.com"
+	SiteGov = "ddog-gov.com"
+)
+
+// DefaultSite is the site used if none is specified
+const DefaultSite = SiteUS1
+
+// SiteURL returns the API base URL of a Datadog site
+func SiteURL(site string) string {
+	return "https://api." + site
+}
+
+// Sink is where a Client sends its metrics when flushed
+type Sink interface {
+	Submit(ctx context.Context, metrics []*Metric) error
+}
+
+// SketchSink is a Sink that can also submit distributions
+type SketchSink interface {
+	Sink
+	SubmitSketches(ctx context.Context, series []*SketchSeries) error
+}
+
+// SinkFunc adapts an ordinary function to a Sink
+type SinkFunc func(ctx context.Context, metrics []*Metric) error
 
+// Submit calls f(ctx, metrics)
+func (f SinkFunc) Submit(ctx context.Context, metrics []*Metric) error {
+	return f(ctx, metrics)
+}
+
+// API is a client of the Datadog HTTP API
 type API struct {
-	apikey  string
-	appkey  string
-	timeout time.Duration
+	apikey   string
+	appkey   string
+	timeout  time.Duration
+	endpoint string // base URL, without the /api/v1 path
+	v2       bool   // use the v2 series API
+	origin   *SeriesOrigin
+
+	compression Compression
+	maxBytes    int // per request, before compression
+	maxSeries   int // per request, 0 is unlimited
+	retry       RetryPolicy
+}
+
+// APIOption configures an API
+type APIOption func(*API)
+
+// WithSite sends to a Datadog site such as SiteEU1.
+func WithSite(site string) APIOption {
+	return func(a *API) {
+		a.endpoint = SiteURL(site)
+	}
+}
+
+// WithEndpoint sends to an explicit base URL, such as a proxy or a
+// test server.  The URL should not include the /api/v1 path.
+func WithEndpoint(baseURL string) APIOption {
+	return func(a *API) {
+		a.endpoint = strings.TrimSuffix(baseURL, "/")
+	}
+}
+
+// WithSeriesV2 submits metrics with the v2 series API, which has
+// units, origin metadata and integer metric types.
+func WithSeriesV2() APIOption {
+	return func(a *API) {
+		a.v2 = true
+	}
 }
 
-func NewAPI(apikey string, appkey string, timeout time.Duration) API {
-	return API{
-		apikey:  apikey,
-		appkey:  appkey,
-		timeout: timeout,
+// WithOrigin sets the origin metadata of v2 series
+func WithOrigin(origin SeriesOrigin) APIOption {
+	return func(a *API) {
+		a.origin = &origin
 	}
 }
 
+// NewAPI creates an API client, by default for the DefaultSite
+func NewAPI(apikey string, appkey string, timeout time.Duration, opts ...APIOption) API {
+	a := API{
+		apikey:   apikey,
+		appkey:   appkey,
+		timeout:  timeout,
+		endpoint: SiteURL(DefaultSite),
+		maxBytes: -1,
+	}
+	for _, opt := range opts {
+		opt(&a)
+	}
+	if a.maxBytes < 0 {
+		a.maxBytes = DefaultMaxBytesV1
+		if a.v2 {
+			a.maxBytes = DefaultMaxBytesV2
+		}
+	}
+	return a
+}
+
 func (a API) AddPoints(metrics []*Metric) error {
+	return a.Submit(context.Background(), metrics)
+}
+
+// Submit posts metrics to the series endpoint, satisfying Sink.
+// Large submissions are split into batches; see WithBatchLimits.
+func (a API) Submit(ctx context.Context, metrics []*Metric) error {
+	batches := a.batch(metrics)
+	if len(batches) == 1 {
+		return a.submitBatch(ctx, batches[0])
+	}
+	var failed []BatchFailure
+	for _, b := range batches {
+		if err := a.submitBatch(ctx, b); err != nil {
+			failed = append(failed, BatchFailure{Metrics: b, Err: err})
+		}
+	}
+	if len(failed) != 0 {
+		return &BatchError{Batches: len(batches), Failed: failed}
+	}
+	return nil
+}
 
+func (a API) submitBatch(ctx context.Context, metrics []*Metric) error {
+	if a.v2 {
+		return a.AddSeries(ctx, a.toSeries(metrics))
+	}
 	post := map[string][]*Metric{
 		"series": metrics,
 	}
-	endpoint := fmt.Sprintf("%s/series?api_key=%s", endpointv1, a.apikey)
+	endpoint := a.endpoint + "/api/v1/series"
+
+	return a.write(ctx, endpoint, post, false)
+}
+
+// SubmitSketches posts distributions to the sketch endpoint,
+// satisfying SketchSink
+func (a API) SubmitSketches(ctx context.Context, series []*SketchSeries) error {
+	endpoint := a.endpoint + "/api/beta/sketches"
 
-	return write(endpoint, post, a.timeout)
+	return a.write(ctx, endpoint, encodeSketches(series), false)
 }
 
 // AddHostTags adds a host tags to a given host.
@@ -45,34 +171,114 @@ func (a API) AddHostTags(host string, source string, tags []string) error {
 	post := map[string][]string{
 		"tags": tags,
 	}
-	endpoint := fmt.Sprintf("%s/tags/hosts/%s?api_key=%s&application_key=%s&source=%s", endpointv1, host, a.apikey, a.appkey, source)
+	endpoint := fmt.Sprintf("%s/api/v1/tags/hosts/%s?source=%s", a.endpoint, url.PathEscape(host), url.QueryEscape(source))
+
+	return a.write(context.Background(), endpoint, post, true)
+}
+
+// String describes the API without revealing the keys
+func (a API) String() string {
+	return fmt.Sprintf("API{endpoint: %q, apikey: %q, appkey: %q}", a.endpoint, mask(a.apikey), mask(a.appkey))
+}
+
+// GoString is the same as String, so %#v doesn't reveal the keys either
+func (a API) GoString() string {
+	return a.String()
+}
+
+// mask hides all but the last 4 characters of a key
+func mask(key string) string {
+	if len(key) <= 4 {
+		return strings.Repeat("*", len(key))
+	}
+	return strings.Repeat("*", len(key)-4) + key[len(key)-4:]
+}
+
+// redact removes any API or application key from an error message.
+// Errors without keys are returned as is.
+func (a API) redact(err error) error {
+	if err == nil {
+		return nil
+	}
+	if se, ok := err.(*StatusError); ok {
+		clean := *se
+		clean.Body = a.redactString(se.Body)
+		return &clean
+	}
+	msg := err.Error()
+	clean := a.redactString(msg)
+	if clean == msg {
+		return err
+	}
+	return errors.New(clean)
+}
 
-	return write(endpoint, post, a.timeout)
+func (a API) redactString(s string) string {
+	for _, key := range []string{a.apikey, a.appkey} {
+		if key != "" {
+			s = strings.Replace(s, key, mask(key), -1)
+		}
+	}
+	return s
 }
 
-// writes a json blob
-func write(endpoint string, data interface{}, timeout time.Duration) error {
-	raw, err := json.Marshal(data)
+// write posts a json blob, authenticating with headers and retrying
+// according to the retry policy.  The application key is only sent
+// if appkey is true.
+func (a API) write(ctx context.Context, endpoint string, data interface{}, appkey bool) error {
+	for attempt := 1; ; attempt++ {
+		err := a.redact(a.post(ctx, endpoint, data, appkey))
+		if err == nil || attempt >= a.retry.MaxAttempts || !IsTemporary(err) {
+			return err
+		}
+		delay, ok := a.retry.delay(attempt, err)
+		if !ok {
+			return err
+		}
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		}
+	}
+}
+
+func (a API) post(ctx context.Context, endpoint string, data interface{}, appkey bool) error {
+	body, err := a.compression.body(data)
 	if err != nil {
 		return err
 	}
 
 	client := &http.Client{
-		Timeout: timeout,
+		Timeout: a.timeout,
 	}
 
-	body := bytes.NewReader(raw)
-	req, err := http.NewRequest(http.MethodPost, endpoint, body)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, body)
 	if err != nil {
+		if rc, ok := body.(io.Closer); ok {
+			rc.Close()
+		}
 		return err
 	}
-	req.Header.Set("Content-Type", "application/json")
+	if _, ok := data.(protobuf); ok {
+		req.Header.Set("Content-Type", "application/x-protobuf")
+	} else {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if a.compression != CompressionNone {
+		req.Header.Set("Content-Encoding", string(a.compression))
+	}
+	req.Header.Set("DD-API-KEY", a.apikey)
+	if appkey {
+		req.Header.Set("DD-APPLICATION-KEY", a.appkey)
+	}
 	resp, err := client.Do(req)
 	if err != nil {
 		if urlErr, ok := err.(*url.Error); ok {
-			// if the error has the url in it,
-			// then retrieve the inner error
-			// and ditch the url (which might contain secrets)
+			// the url no longer has secrets, but the
+			// inner error is all that is useful
 			err = urlErr.Err
 		}
 		return err
@@ -89,5 +295,9 @@ func write(endpoint string, data interface{}, timeout time.Duration) error {
 		return nil
 	}
 
-	return fmt.Errorf("http status %v: %s", resp.StatusCode, string(responseBody))
+	return &StatusError{
+		StatusCode: resp.StatusCode,
+		Body:       string(responseBody),
+		RetryAfter: retryAfter(resp.Header, time.Now()),
+	}
 }
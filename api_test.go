@@ -0,0 +1,71 @@
+package dogdirect
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+var siteCases = []struct {
+	opts []APIOption
+	want string
+}{
+	{nil, "https://api.datadoghq.com"},
+	{[]APIOption{WithSite(SiteEU1)}, "https://api.datadoghq.eu"},
+	{[]APIOption{WithSite(SiteUS3)}, "https://api.us3.datadoghq.com"},
+	{[]APIOption{WithSite(SiteGov)}, "https://api.ddog-gov.com"},
+	{[]APIOption{WithEndpoint("http://localhost:8080/")}, "http://localhost:8080"},
+}
+
+func TestAPISite(t *testing.T) {
+	for i, tt := range siteCases {
+		api := NewAPI("foo", "bar", 0, tt.opts...)
+		if api.endpoint != tt.want {
+			t.Errorf("Case %d: got %q, want %q", i, api.endpoint, tt.want)
+		}
+	}
+}
+
+func TestAPIKeyHeaders(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.RawQuery != "" && r.URL.Query().Get("source") == "" {
+			t.Errorf("unexpected query %q", r.URL.RawQuery)
+		}
+		if got := r.Header.Get("DD-API-KEY"); got != "secretapikey" {
+			t.Errorf("got api key %q", got)
+		}
+		appkey := r.Header.Get("DD-APPLICATION-KEY")
+		if strings.HasSuffix(r.URL.Path, "/series") && appkey != "" {
+			t.Errorf("app key sent to %s", r.URL.Path)
+		}
+		if strings.Contains(r.URL.Path, "/tags/hosts/") && appkey != "secretappkey" {
+			t.Errorf("got app key %q", appkey)
+		}
+		// some servers echo back what they got
+		w.WriteHeader(http.StatusForbidden)
+		fmt.Fprintf(w, "bad key %s", r.Header.Get("DD-API-KEY"))
+	}))
+	defer ts.Close()
+
+	api := NewAPI("secretapikey", "secretappkey", 0, WithEndpoint(ts.URL))
+	errs := []error{
+		api.AddPoints([]*Metric{NewMetric("foo", TypeGauge, nil)}),
+		api.AddHostTags("hostname", "", []string{"tag1"}),
+	}
+	for i, err := range errs {
+		if err == nil {
+			t.Fatalf("Case %d: expected error", i)
+		}
+		if strings.Contains(err.Error(), "secretapikey") {
+			t.Errorf("Case %d: error contains key: %v", i, err)
+		}
+	}
+
+	for _, s := range []string{fmt.Sprint(api), fmt.Sprintf("%+v", api), fmt.Sprintf("%#v", api)} {
+		if strings.Contains(s, "secret") {
+			t.Errorf("formatted API contains key: %s", s)
+		}
+	}
+}
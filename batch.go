@@ -0,0 +1,187 @@
+package dogdirect
+
+import (
+	"errors"
+	"fmt"
+	"unicode/utf8"
+)
+
+// Datadog rejects series payloads larger than these, compressed or
+// not.  Limiting the uncompressed size to the compressed limit is
+// conservative but always safe.
+const (
+	DefaultMaxBytesV1 = 3200000
+	DefaultMaxBytesV2 = 512000
+)
+
+// bytes of {"series":[]} wrapped around each batch
+const batchOverhead = len(`{"series":[]}`)
+
+// WithBatchLimits splits submissions into batches of at most maxBytes
+// of JSON, before compression, and at most maxSeries series.  Zero
+// means unlimited.  The default is a byte limit of DefaultMaxBytesV1
+// or DefaultMaxBytesV2 and no series limit.
+func WithBatchLimits(maxBytes int, maxSeries int) APIOption {
+	return func(a *API) {
+		a.maxBytes = maxBytes
+		a.maxSeries = maxSeries
+	}
+}
+
+// BatchFailure is a batch of metrics that failed to submit
+type BatchFailure struct {
+	Metrics []*Metric
+	Err     error
+}
+
+// BatchError is returned when some batches of a submission failed.
+// The other batches were submitted successfully.
+type BatchError struct {
+	Batches int // number of batches in the submission
+	Failed  []BatchFailure
+}
+
+func (e *BatchError) Error() string {
+	return fmt.Sprintf("%d of %d batches failed: %v", len(e.Failed), e.Batches, e.Failed[0].Err)
+}
+
+// Is reports whether the error of any failed batch is target, for
+// errors.Is
+func (e *BatchError) Is(target error) bool {
+	for _, f := range e.Failed {
+		if errors.Is(f.Err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// As finds the first error of a failed batch that is target, for
+// errors.As
+func (e *BatchError) As(target interface{}) bool {
+	for _, f := range e.Failed {
+		if errors.As(f.Err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// FailedMetrics returns the metrics that were not submitted, given
+// the error from submitting them
+func FailedMetrics(err error, metrics []*Metric) []*Metric {
+	if err == nil {
+		return nil
+	}
+	be, ok := err.(*BatchError)
+	if !ok {
+		return metrics
+	}
+	var failed []*Metric
+	for _, f := range be.Failed {
+		failed = append(failed, f.Metrics...)
+	}
+	return failed
+}
+
+// Upper bounds on the JSON around the strings of a metric, and on
+// each point, for size.  Numbers are at most 25 bytes.
+const (
+	metricOverheadV1 = 96  // field names, punctuation and interval
+	pointSizeV1      = 56  // [ts,value],
+	metricOverheadV2 = 320 // also resources and origin metadata
+	pointSizeV2      = 72  // {"timestamp":ts,"value":value},
+)
+
+// size is an upper bound on the encoded size of a single metric.  It
+// is much cheaper than encoding it, which only happens once, when the
+// batch is sent.
+func (a API) size(m *Metric) int {
+	n := metricOverheadV1 + len(m.Value)*pointSizeV1
+	if a.v2 {
+		n = metricOverheadV2 + len(m.Value)*pointSizeV2 + quotedSize(m.Unit)
+	}
+	n += quotedSize(m.Name) + quotedSize(m.Type) + quotedSize(m.Hostname)
+	for _, tag := range m.Tags {
+		// plus a comma
+		n += quotedSize(tag) + 1
+	}
+	return n
+}
+
+// quotedSize is the length of s as a JSON string, escaped the way
+// encoding/json does
+func quotedSize(s string) int {
+	n := 2
+	for i := 0; i < len(s); {
+		c := s[i]
+		if c < utf8.RuneSelf {
+			switch {
+			case c == '"' || c == '\\' || c == '\n' || c == '\r' || c == '\t':
+				n += 2
+			case c < 0x20 || c == '<' || c == '>' || c == '&':
+				n += 6 // \u00XX
+			default:
+				n++
+			}
+			i++
+			continue
+		}
+		r, size := utf8.DecodeRuneInString(s[i:])
+		if (r == utf8.RuneError && size == 1) || r == '\u2028' || r == '\u2029' {
+			n += 6 // \ufffd or \u202X
+		} else {
+			n += size
+		}
+		i += size
+	}
+	return n
+}
+
+// batch splits metrics to respect the batch limits
+func (a API) batch(metrics []*Metric) [][]*Metric {
+	if a.maxBytes <= 0 && a.maxSeries <= 0 {
+		return [][]*Metric{metrics}
+	}
+	var batches [][]*Metric
+	start, total := 0, batchOverhead
+	for i, m := range metrics {
+		n := 0
+		if a.maxBytes > 0 {
+			// plus a comma
+			n = a.size(m) + 1
+		}
+		full := a.maxSeries > 0 && i-start >= a.maxSeries
+		if a.maxBytes > 0 && total+n > a.maxBytes {
+			full = true
+		}
+		if full && i > start {
+			batches = append(batches, metrics[start:i])
+			start, total = i, batchOverhead
+		}
+		total += n
+	}
+	return append(batches, metrics[start:])
+}
+
+// retryable returns the metrics that failed with a temporary error,
+// and so might succeed if submitted again later
+func retryable(err error, metrics []*Metric) []*Metric {
+	if err == nil {
+		return nil
+	}
+	be, ok := err.(*BatchError)
+	if !ok {
+		if IsTemporary(err) {
+			return metrics
+		}
+		return nil
+	}
+	var failed []*Metric
+	for _, f := range be.Failed {
+		if IsTemporary(f.Err) {
+			failed = append(failed, f.Metrics...)
+		}
+	}
+	return failed
+}
@@ -0,0 +1,121 @@
+package dogdirect
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+func TestBatchLimits(t *testing.T) {
+	metrics := make([]*Metric, 10)
+	for i := range metrics {
+		metrics[i] = NewMetric(fmt.Sprintf("metric%d", i), TypeGauge, nil)
+	}
+	sz := API{}.size(metrics[0]) + 1
+
+	cases := []struct {
+		maxBytes  int
+		maxSeries int
+		want      []int
+	}{
+		{0, 0, []int{10}},
+		{0, 3, []int{3, 3, 3, 1}},
+		{batchOverhead + 4*sz, 0, []int{4, 4, 2}},
+		{batchOverhead + 4*sz, 3, []int{3, 3, 3, 1}},
+		{1, 0, []int{1, 1, 1, 1, 1, 1, 1, 1, 1, 1}},
+	}
+	for i, tt := range cases {
+		api := NewAPI("foo", "bar", 0, WithBatchLimits(tt.maxBytes, tt.maxSeries))
+		batches := api.batch(metrics)
+		var got []int
+		for _, b := range batches {
+			got = append(got, len(b))
+		}
+		if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+			t.Errorf("Case %d: got batches %v, want %v", i, got, tt.want)
+		}
+	}
+}
+
+func TestBatchSize(t *testing.T) {
+	metrics := []*Metric{
+		NewMetric("plain", TypeGauge, nil),
+		{
+			Name:     "escaped\"\\\n\x01<>&",
+			Value:    [][2]float64{{1640995200, -0.0000012345678901234567}, {1e300, -1.2345678901234567e-308}},
+			Type:     TypeRate,
+			Hostname: "h\u00e9te\u2028",
+			Tags:     []string{"a:b", "bad:\xff\xfe", "\u65e5\u672c"},
+			Interval: 1 << 40,
+			Unit:     "millisecond",
+		},
+	}
+	for i := 0; i < 50; i++ {
+		m := NewMetric(fmt.Sprintf("metric.%d", i), TypeCount, []string{fmt.Sprintf("n:%d", i)})
+		m.Hostname = "hostname"
+		m.Interval = 15
+		for j := 0; j < i; j++ {
+			m.Value = append(m.Value, [2]float64{float64(1640995200 + j), float64(j) / 7})
+		}
+		metrics = append(metrics, m)
+	}
+	origin := SeriesOrigin{Product: 1 << 40, SubProduct: 1 << 40, ProductDetail: 1 << 40}
+	for _, api := range []API{NewAPI("", "", 0), NewAPI("", "", 0, WithSeriesV2(), WithOrigin(origin))} {
+		for _, m := range metrics {
+			var raw []byte
+			var err error
+			if api.v2 {
+				raw, err = json.Marshal(api.toSeries([]*Metric{m})[0])
+			} else {
+				raw, err = json.Marshal(m)
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			// an upper bound, but not wildly so
+			if got := api.size(m); got < len(raw) || got > 2*len(raw)+400 {
+				t.Errorf("v2 %v: size of %s is %d, encoded is %d", api.v2, m.Name, got, len(raw))
+			}
+		}
+	}
+}
+
+func TestBatchError(t *testing.T) {
+	var requests int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// fail every other request
+		if atomic.AddInt32(&requests, 1)%2 == 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer ts.Close()
+
+	metrics := make([]*Metric, 5)
+	for i := range metrics {
+		metrics[i] = NewMetric(fmt.Sprintf("metric%d", i), TypeGauge, nil)
+	}
+	api := NewAPI("foo", "bar", 0, WithEndpoint(ts.URL), WithBatchLimits(0, 2))
+	err := api.Submit(context.Background(), metrics)
+	be, ok := err.(*BatchError)
+	if !ok {
+		t.Fatalf("got error %v, want a *BatchError", err)
+	}
+	if be.Batches != 3 || len(be.Failed) != 1 {
+		t.Errorf("got %d of %d batches failed, want 1 of 3", len(be.Failed), be.Batches)
+	}
+	failed := FailedMetrics(err, metrics)
+	if len(failed) != 2 || failed[0] != metrics[2] || failed[1] != metrics[3] {
+		t.Errorf("got failed metrics %v", failed)
+	}
+	var se *StatusError
+	if !errors.As(err, &se) || se.StatusCode != http.StatusBadRequest {
+		t.Errorf("errors.As(err, *StatusError) got %v", se)
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"log"
 	"os"
@@ -12,13 +13,10 @@ import (
 	"github.com/signalsciences/dogdirect/hostmetrics"
 )
 
-var (
-	client    *dogdirect.Client
-	namespace string
-)
+var client *dogdirect.Client
 
 func gauge(args []string) ([]string, error) {
-	name := namespace + args[0]
+	name := args[0]
 	log.Printf("gauge %s", name)
 	val, err := strconv.ParseFloat(args[1], 64)
 	if err != nil {
@@ -28,7 +26,7 @@ func gauge(args []string) ([]string, error) {
 	return args[2:], nil
 }
 func count(args []string) ([]string, error) {
-	name := namespace + args[0]
+	name := args[0]
 	log.Printf("count %s", name)
 	val, err := strconv.ParseFloat(args[1], 64)
 	if err != nil {
@@ -38,15 +36,26 @@ func count(args []string) ([]string, error) {
 	return args[2:], nil
 }
 
+func rate(args []string) ([]string, error) {
+	name := args[0]
+	log.Printf("rate %s", name)
+	val, err := strconv.ParseFloat(args[1], 64)
+	if err != nil {
+		return nil, err
+	}
+	client.Rate(name, val, nil)
+	return args[2:], nil
+}
+
 func incr(args []string) ([]string, error) {
-	name := namespace + args[0]
+	name := args[0]
 	log.Printf("incr %s", name)
 	client.Incr(name, nil)
 	return args[1:], nil
 }
 
 func decr(args []string) ([]string, error) {
-	name := namespace + args[0]
+	name := args[0]
 	log.Printf("decr %s", name)
 	client.Decr(name, nil)
 	return args[1:], nil
@@ -68,6 +77,14 @@ func flush(args []string) ([]string, error) {
 	return args, err
 }
 
+func splitCSV(s string) []string {
+	tags := strings.Split(s, ",")
+	for i, t := range tags {
+		tags[i] = strings.TrimSpace(t)
+	}
+	return tags
+}
+
 type cmdfn func([]string) ([]string, error)
 
 var cmdmap = map[string]cmdfn{
@@ -79,6 +96,8 @@ var cmdmap = map[string]cmdfn{
 	"decr":  decr,
 	"c":     count,
 	"count": count,
+	"r":     rate,
+	"rate":  rate,
 	"s":     sleep,
 	"sleep": sleep,
 	"f":     flush,
@@ -88,19 +107,25 @@ var cmdmap = map[string]cmdfn{
 func main() {
 	var err error
 	var hostname string
+	var opts []dogdirect.Option
 	flagNS := flag.String("namespace", "", "sets global namespace")
 	flagHostname := flag.String("hostname", "", "hostname, if empty use OS")
+	flagTags := flag.String("tags", "", "CSV of global tags")
 	flagHostTags := flag.String("hosttags", "", "CSV of host tags")
 	flagSystem := flag.Bool("system", false, "emit system host metrics")
+	flagSpool := flag.String("spool", "", "directory to save failed metrics, if empty don't")
+	flagSite := flag.String("site", "", "datadog site or API URL, if empty use $DD_SITE or "+dogdirect.DefaultSite)
 
 	flag.Parse()
 
 	if *flagNS != "" {
-		namespace = *flagNS
-		if namespace[len(namespace)-1] != '.' {
-			namespace += "."
-		}
-		log.Printf("setting namespace to %q", namespace)
+		log.Printf("setting namespace to %q", *flagNS)
+		opts = append(opts, dogdirect.WithNamespace(*flagNS))
+	}
+	if *flagTags != "" {
+		tags := splitCSV(*flagTags)
+		log.Printf("setting global tags to %v", tags)
+		opts = append(opts, dogdirect.WithTags(tags...))
 	}
 
 	// set hostname
@@ -116,15 +141,48 @@ func main() {
 	}
 	log.Printf("setting hostname to %q", hostname)
 
+	// set site, like the agent's DD_SITE
+	site := *flagSite
+	if site == "" {
+		site = os.Getenv("DD_SITE")
+	}
+	if site == "" {
+		site = dogdirect.DefaultSite
+	}
+	siteOpt := dogdirect.WithSite(site)
+	if strings.HasPrefix(site, "http://") || strings.HasPrefix(site, "https://") {
+		siteOpt = dogdirect.WithEndpoint(site)
+	}
+	log.Printf("setting site to %q", site)
+
 	// todo - set timeout via flag
-	api := dogdirect.NewAPI(os.Getenv("DD_API_KEY"), os.Getenv("DD_APP_KEY"), 5*time.Second)
+	api := dogdirect.NewAPI(os.Getenv("DD_API_KEY"), os.Getenv("DD_APP_KEY"), 5*time.Second, siteOpt,
+		dogdirect.WithRetry(dogdirect.DefaultRetryPolicy))
 
 	// create main metrics
-	client = dogdirect.New(hostname, api)
+	if *flagSpool != "" {
+		spool, err := dogdirect.NewSpool(*flagSpool, 64*1024*1024, 0)
+		if err != nil {
+			log.Fatalf("unable to create spool: %v", err)
+		}
+		log.Printf("spooling failed metrics to %q", *flagSpool)
+		opts = append(opts, dogdirect.WithSpool(spool))
+	}
+
+	opts = append(opts, dogdirect.WithHostname(hostname), dogdirect.WithSink(api))
+	client = dogdirect.NewClient(opts...)
+	logger := log.New(os.Stderr, "", log.LstdFlags)
 	tasks := dogdirect.MultiTask{
-		dogdirect.NewPeriodic(client, time.Second*15),
+		dogdirect.NewPeriodic(client, client.FlushInterval(), dogdirect.WithLogger(logger),
+			dogdirect.WithAlignment(), dogdirect.WithSplay(time.Second)),
 	}
-	defer tasks.Close()
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := tasks.Shutdown(ctx); err != nil {
+			log.Printf("shutdown failed: %v", err)
+		}
+	}()
 
 	// send system metrics?
 	if *flagSystem {
@@ -133,15 +191,12 @@ func main() {
 			log.Fatalf("unable create system metrics: %v", err)
 		}
 		log.Printf("turning on system metrics")
-		tasks = append(tasks, dogdirect.NewPeriodic(t, time.Second*15))
+		tasks = append(tasks, dogdirect.NewPeriodic(t, client.FlushInterval(), dogdirect.WithLogger(logger)))
 	}
 
 	// set host tags?
 	if *flagHostTags != "" {
-		tags := strings.Split(*flagHostTags, ",")
-		for i, t := range tags {
-			tags[i] = strings.TrimSpace(t)
-		}
+		tags := splitCSV(*flagHostTags)
 		log.Printf("setting host tags to %v", tags)
 		t := dogdirect.NewHostTagger(api, hostname, tags)
 		tasks = append(tasks, dogdirect.NewPeriodic(t, time.Second*30))
@@ -0,0 +1,73 @@
+package dogdirect
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// Compression is the Content-Encoding of request bodies
+type Compression string
+
+// Supported compressions.  Datadog's "deflate" is the zlib format.
+const (
+	CompressionNone    Compression = ""
+	CompressionGzip    Compression = "gzip"
+	CompressionDeflate Compression = "deflate"
+)
+
+// WithCompression compresses request bodies.  Compressed bodies are
+// encoded as they are sent, so the uncompressed JSON is never held in
+// memory.
+func WithCompression(c Compression) APIOption {
+	return func(a *API) {
+		a.compression = c
+	}
+}
+
+// body returns the JSON encoding of data, unless it is already
+// encoded protobuf, compressed if needed
+func (c Compression) body(data interface{}) (io.Reader, error) {
+	switch c {
+	case CompressionNone:
+		if pb, ok := data.(protobuf); ok {
+			return bytes.NewReader(pb), nil
+		}
+		raw, err := json.Marshal(data)
+		if err != nil {
+			return nil, err
+		}
+		return bytes.NewReader(raw), nil
+	case CompressionGzip, CompressionDeflate:
+		pr, pw := io.Pipe()
+		go func() {
+			pw.CloseWithError(c.encode(pw, data))
+		}()
+		return pr, nil
+	}
+	return nil, fmt.Errorf("unknown compression %q", string(c))
+}
+
+// encode writes compressed JSON, or protobuf, to w
+func (c Compression) encode(w io.Writer, data interface{}) error {
+	var zw io.WriteCloser
+	if c == CompressionGzip {
+		zw = gzip.NewWriter(w)
+	} else {
+		zw = zlib.NewWriter(w)
+	}
+	var err error
+	if pb, ok := data.(protobuf); ok {
+		_, err = zw.Write(pb)
+	} else {
+		err = json.NewEncoder(zw).Encode(data)
+	}
+	if err != nil {
+		zw.Close()
+		return err
+	}
+	return zw.Close()
+}
@@ -0,0 +1,55 @@
+package dogdirect
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCompression(t *testing.T) {
+	for _, c := range []Compression{CompressionNone, CompressionGzip, CompressionDeflate} {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			defer r.Body.Close()
+			if got := r.Header.Get("Content-Encoding"); got != string(c) {
+				t.Errorf("got Content-Encoding %q, want %q", got, c)
+			}
+			var body io.Reader = r.Body
+			var err error
+			switch c {
+			case CompressionGzip:
+				body, err = gzip.NewReader(r.Body)
+			case CompressionDeflate:
+				body, err = zlib.NewReader(r.Body)
+			}
+			if err != nil {
+				t.Fatalf("%s: can't decompress: %v", c, err)
+			}
+			got, err := io.ReadAll(body)
+			if err != nil {
+				t.Fatalf("%s: failed to read request body: %v", c, err)
+			}
+			want := `{"series":[{"metric":"foo","points":[[0,0]],"type":"gauge"}]}`
+			if strings.TrimSpace(string(got)) != want {
+				t.Errorf("%s: got %s, want %s", c, got, want)
+			}
+			w.WriteHeader(http.StatusAccepted)
+		}))
+
+		api := NewAPI("foo", "bar", 0, WithEndpoint(ts.URL), WithCompression(c))
+		m := NewMetric("foo", TypeGauge, nil)
+		m.Value = [][2]float64{{0, 0}}
+		if err := api.AddPoints([]*Metric{m}); err != nil {
+			t.Errorf("%s: %v", c, err)
+		}
+		ts.Close()
+	}
+
+	api := NewAPI("foo", "bar", 0, WithCompression("br"))
+	if err := api.AddPoints(nil); err == nil {
+		t.Errorf("expected error with unknown compression")
+	}
+}
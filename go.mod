@@ -1,6 +1,6 @@
 module github.com/signalsciences/dogdirect
 
-go 1.12
+go 1.13
 
 require (
 	github.com/HdrHistogram/hdrhistogram-go v1.1.2
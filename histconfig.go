@@ -0,0 +1,112 @@
+package dogdirect
+
+import (
+	"math"
+	"strconv"
+)
+
+// Histogram aggregates, as named by the agent's histogram_aggregates
+const (
+	AggregateMin    = "min"
+	AggregateMax    = "max"
+	AggregateSum    = "sum"
+	AggregateAvg    = "avg"
+	AggregateMedian = "median"
+	AggregateCount  = "count"
+
+	// AggregateStdDev is not one of the agent's, but is available
+	AggregateStdDev = "stddev"
+)
+
+// HistogramConfig selects the metrics sent for a histogram, like the
+// agent's histogram_aggregates and histogram_percentiles settings.
+// Each is sent as the histogram name plus a suffix, such as
+// "latency.max" or "latency.99percentile".
+type HistogramConfig struct {
+	Aggregates  []string  // such as AggregateMax
+	Percentiles []float64 // between 0 and 1, such as 0.95
+}
+
+// DefaultHistogramConfig is the same as the agent's default
+var DefaultHistogramConfig = HistogramConfig{
+	Aggregates:  []string{AggregateMax, AggregateMedian, AggregateAvg, AggregateCount},
+	Percentiles: []float64{0.95},
+}
+
+// WithHistogramConfig sets the metrics sent for every histogram.
+// The default is DefaultHistogramConfig.
+func WithHistogramConfig(cfg HistogramConfig) Option {
+	return func(c *Client) {
+		c.histConfig = cfg
+	}
+}
+
+// WithHistogramConfigFor sets the metrics sent for the histogram
+// called name, overriding WithHistogramConfig
+func WithHistogramConfigFor(name string, cfg HistogramConfig) Option {
+	return func(c *Client) {
+		if c.histConfigs == nil {
+			c.histConfigs = make(map[string]HistogramConfig)
+		}
+		c.histConfigs[name] = cfg
+	}
+}
+
+// percentileSuffix names a percentile like the agent, so 0.95 is
+// "95percentile" and 0.999 is "99.9percentile"
+func percentileSuffix(p float64) string {
+	return strconv.FormatFloat(math.Round(p*1e4)/1e2, 'f', -1, 64) + "percentile"
+}
+
+// percentile returns quantile p of a flushed histogram, if available
+func percentile(h Histogram, hr HistogramResult, p float64) (float64, bool) {
+	if q, ok := h.(Quantiler); ok {
+		return q.Quantile(p), true
+	}
+	switch p {
+	case 0.5:
+		return hr.Median, true
+	case 0.95:
+		return hr.P95, true
+	}
+	return 0, false
+}
+
+// flushHistogram records the configured metrics of a histogram.
+// Histograms are not bucketed, so these use the flush time.  The
+// histogram is reset afterwards.  Not locked.
+func (c *Client) flushHistogram(h *histogramContext) {
+	defer h.hist.Reset()
+	hr := h.hist.Flush()
+	if hr.Count == 0 {
+		return
+	}
+	cfg, ok := c.histConfigs[h.name]
+	if !ok {
+		cfg = c.histConfig
+	}
+	for _, agg := range cfg.Aggregates {
+		name := h.name + "." + agg
+		switch agg {
+		case AggregateMin:
+			c.record(name, TypeGauge, h.tags, 0, hr.Min)
+		case AggregateMax:
+			c.record(name, TypeGauge, h.tags, 0, hr.Max)
+		case AggregateSum:
+			c.record(name, TypeGauge, h.tags, 0, hr.Sum)
+		case AggregateAvg:
+			c.record(name, TypeGauge, h.tags, 0, hr.Avg)
+		case AggregateMedian:
+			c.record(name, TypeGauge, h.tags, 0, hr.Median)
+		case AggregateCount:
+			c.record(name, c.countType, h.tags, 0, hr.Count)
+		case AggregateStdDev:
+			c.record(name, TypeGauge, h.tags, 0, hr.StdDev)
+		}
+	}
+	for _, p := range cfg.Percentiles {
+		if val, ok := percentile(h.hist, hr, p); ok {
+			c.record(h.name+"."+percentileSuffix(p), TypeGauge, h.tags, 0, val)
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package dogdirect
 
 import (
+	"math"
 	"sort"
 )
 
@@ -10,11 +11,29 @@ type HistogramResult struct {
 	Count  float64
 	Min    float64
 	Max    float64
+	Sum    float64
 	Avg    float64
+	StdDev float64 // of the population, not a sample
 	Median float64
 	P95    float64
 }
 
+// Histogram accumulates values and computes descriptive statistics.
+// Flush computes the statistics of the values added so far, and
+// Reset discards them so the histogram can be reused.
+type Histogram interface {
+	Add(val float64)
+	Flush() HistogramResult
+	Reset()
+}
+
+// Quantiler is a Histogram that can compute any quantile, between 0
+// and 1, after Flush.  Without it, only the median and 95th
+// percentile are available.
+type Quantiler interface {
+	Quantile(q float64) float64
+}
+
 // ExactHistogram is the dumbest way possible to compute various descriptive statistics
 //  It keeps all data, does a sort, and the figures out various stats.
 //  That said for 1000 elements, it takes under 1/20 of a millisecond to compute.
@@ -24,8 +43,29 @@ type HistogramResult struct {
 type ExactHistogram struct {
 	samples []float64
 	tags    []string
+	method  PercentileMethod
 }
 
+// PercentileMethod is how an ExactHistogram picks a percentile from
+// its sorted samples when it falls between two of them
+type PercentileMethod int
+
+// Percentile methods
+const (
+	// AgentRank picks the sample at index round(p*n-1), rounding
+	// as the agent does, so percentiles match those of dogstatsd.
+	// This is the default.
+	AgentRank PercentileMethod = iota
+
+	// NearestRank picks the sample at rank ceil(p*n), the textbook
+	// nearest-rank method, which never picks a sample below p.
+	NearestRank
+
+	// Linear interpolates between the two closest samples, the same
+	// as numpy's default and Excel's PERCENTILE.INC.
+	Linear
+)
+
 // NewExactHistogram creates a new object
 func NewExactHistogram(points int, tags []string) *ExactHistogram {
 	if points == 0 {
@@ -37,11 +77,26 @@ func NewExactHistogram(points int, tags []string) *ExactHistogram {
 	}
 }
 
+func newExactHistogram() Histogram {
+	return NewExactHistogram(1000, nil)
+}
+
 // Add adds a data point
 func (he *ExactHistogram) Add(val float64) {
 	he.samples = append(he.samples, val)
 }
 
+// SetMethod sets how percentiles, including the median, are
+// computed.  The default is AgentRank.
+func (he *ExactHistogram) SetMethod(method PercentileMethod) {
+	he.method = method
+}
+
+// Reset discards all data points, keeping the memory for reuse
+func (he *ExactHistogram) Reset() {
+	he.samples = he.samples[:0]
+}
+
 // Flush needs to be renamed, but computes the data
 func (he *ExactHistogram) Flush() HistogramResult {
 	if len(he.samples) == 0 {
@@ -55,13 +110,54 @@ func (he *ExactHistogram) Flush() HistogramResult {
 	for _, val := range he.samples {
 		sum += val
 	}
+	avg := sum / float64(count)
+	variance := 0.0
+	for _, val := range he.samples {
+		variance += (val - avg) * (val - avg)
+	}
+	variance /= float64(count)
 
 	return HistogramResult{
 		Count:  float64(count),
 		Min:    he.samples[0],
 		Max:    he.samples[count-1],
-		Avg:    sum / float64(count),
-		Median: he.samples[count/2],
-		P95:    he.samples[(count*95)/100],
+		Sum:    sum,
+		Avg:    avg,
+		StdDev: math.Sqrt(variance),
+		Median: he.Quantile(0.5),
+		P95:    he.Quantile(0.95),
+	}
+}
+
+// Quantile returns the value at quantile q, using the samples sorted
+// by Flush
+func (he *ExactHistogram) Quantile(q float64) float64 {
+	count := len(he.samples)
+	if count == 0 {
+		return 0
+	}
+	switch he.method {
+	case Linear:
+		rank := q * float64(count-1)
+		lo := clampIndex(int(math.Floor(rank)), count)
+		hi := clampIndex(lo+1, count)
+		frac := math.Min(math.Max(rank-float64(lo), 0), 1)
+		return he.samples[lo] + frac*(he.samples[hi]-he.samples[lo])
+	case NearestRank:
+		// the small constant avoids rounding 0.95*20 up to 20
+		return he.samples[clampIndex(int(math.Ceil(q*float64(count)-1e-9))-1, count)]
+	}
+	// as the agent does, which for the median is the lower of the
+	// middle two samples
+	return he.samples[clampIndex(int(math.Round(q*float64(count)-1)), count)]
+}
+
+func clampIndex(i int, count int) int {
+	if i >= count {
+		return count - 1
+	}
+	if i < 0 {
+		return 0
 	}
+	return i
 }
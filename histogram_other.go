@@ -0,0 +1,208 @@
+package dogdirect
+
+import (
+	"math"
+
+	"github.com/HdrHistogram/hdrhistogram-go"
+	"github.com/VividCortex/gohistogram"
+)
+
+// bounds tracks the exact count, min, max, sum and standard
+// deviation of a histogram, as the estimators only approximate them
+type bounds struct {
+	count float64
+	min   float64
+	max   float64
+	sum   float64
+	mean  float64 // running, for m2
+	m2    float64 // sum of squared differences from the mean
+}
+
+func (b *bounds) add(val float64) {
+	if b.count == 0 || val < b.min {
+		b.min = val
+	}
+	if b.count == 0 || val > b.max {
+		b.max = val
+	}
+	b.count++
+	b.sum += val
+
+	// Welford's algorithm, which is stable unlike the sum of squares
+	delta := val - b.mean
+	b.mean += delta / b.count
+	b.m2 += delta * (val - b.mean)
+}
+
+// clamp keeps an estimate within the values actually seen
+func (b *bounds) clamp(val float64) float64 {
+	return math.Min(math.Max(val, b.min), b.max)
+}
+
+func (b *bounds) result(q func(float64) float64) HistogramResult {
+	if b.count == 0 {
+		return HistogramResult{}
+	}
+	return HistogramResult{
+		Count:  b.count,
+		Min:    b.min,
+		Max:    b.max,
+		Sum:    b.sum,
+		Avg:    b.sum / b.count,
+		StdDev: math.Sqrt(b.m2 / b.count),
+		Median: q(0.5),
+		P95:    q(0.95),
+	}
+}
+
+// HDRHistogram is a Histogram backed by an HdrHistogram, which uses
+// a fixed amount of memory to record integers between lowest and
+// highest to a number of significant figures (1 to 5).
+//
+// Values are rounded to integers, so scale them to suit; Timing is in
+// milliseconds.  Values outside the range are clamped to it for the
+// percentiles, but the count, min, max, sum, average and standard
+// deviation are exact.
+//
+// For example, for timings up to a minute to 3 significant figures:
+//
+//	WithHistogramFactory(func() Histogram {
+//		return NewHDRHistogram(1, 60000, 3)
+//	})
+type HDRHistogram struct {
+	hist   *hdrhistogram.Histogram
+	bounds bounds
+}
+
+// NewHDRHistogram creates a new HDRHistogram
+func NewHDRHistogram(lowest, highest int64, sigfigs int) *HDRHistogram {
+	return &HDRHistogram{
+		hist: hdrhistogram.New(lowest, highest, sigfigs),
+	}
+}
+
+// Add adds a data point
+func (h *HDRHistogram) Add(val float64) {
+	h.bounds.add(val)
+	v := int64(math.Round(val))
+	if lo := h.hist.LowestTrackableValue(); v < lo {
+		v = lo
+	}
+	if hi := h.hist.HighestTrackableValue(); v > hi {
+		v = hi
+	}
+	h.hist.RecordValue(v)
+}
+
+// Flush computes the statistics
+func (h *HDRHistogram) Flush() HistogramResult {
+	return h.bounds.result(h.Quantile)
+}
+
+// Quantile returns the estimated value at quantile q
+func (h *HDRHistogram) Quantile(q float64) float64 {
+	if h.bounds.count == 0 {
+		return 0
+	}
+	return h.bounds.clamp(float64(h.hist.ValueAtPercentile(q * 100)))
+}
+
+// Reset discards all data points
+func (h *HDRHistogram) Reset() {
+	h.hist.Reset()
+	h.bounds = bounds{}
+}
+
+// StreamingHistogram is a Histogram backed by a streaming histogram
+// (Ben-Haim and Tom-Tov) of a fixed number of bins.  It takes any
+// values, but its percentiles are approximate with no error bound;
+// more bins are more accurate but slower.  The count, min, max, sum,
+// average and standard deviation are exact.
+type StreamingHistogram struct {
+	bins   int
+	hist   *gohistogram.NumericHistogram
+	bounds bounds
+}
+
+// NewStreamingHistogram creates a new StreamingHistogram
+func NewStreamingHistogram(bins int) *StreamingHistogram {
+	return &StreamingHistogram{
+		bins: bins,
+		hist: gohistogram.NewHistogram(bins),
+	}
+}
+
+// Add adds a data point
+func (h *StreamingHistogram) Add(val float64) {
+	h.bounds.add(val)
+	h.hist.Add(val)
+}
+
+// Flush computes the statistics
+func (h *StreamingHistogram) Flush() HistogramResult {
+	return h.bounds.result(h.Quantile)
+}
+
+// Quantile returns the estimated value at quantile q
+func (h *StreamingHistogram) Quantile(q float64) float64 {
+	if h.bounds.count == 0 {
+		return 0
+	}
+	if q >= 1 {
+		return h.bounds.max
+	}
+	return h.bounds.clamp(h.hist.Quantile(q))
+}
+
+// Reset discards all data points
+func (h *StreamingHistogram) Reset() {
+	h.hist = gohistogram.NewHistogram(h.bins)
+	h.bounds = bounds{}
+}
+
+// SketchHistogram is a Histogram backed by a Sketch, the DDSketch
+// used for distributions.  Percentiles are accurate to within
+// SketchRelativeAccuracy (under 1%) of the true value, for any number
+// of values, and it never uses more than a few thousand bins (tens
+// of kilobytes), even for values spanning many orders of magnitude.
+// Values closer to zero than 1e-9 count as zero.  The count, min,
+// max, sum, average and standard deviation are exact.
+//
+// It is the default for Timing.
+type SketchHistogram struct {
+	sketch *Sketch
+	bounds bounds
+}
+
+// NewSketchHistogram creates a new SketchHistogram
+func NewSketchHistogram() *SketchHistogram {
+	return &SketchHistogram{
+		sketch: NewSketch(),
+	}
+}
+
+func newSketchHistogram() Histogram {
+	return NewSketchHistogram()
+}
+
+// Add adds a data point
+func (h *SketchHistogram) Add(val float64) {
+	h.bounds.add(val)
+	h.sketch.Add(val)
+}
+
+// Flush computes the statistics
+func (h *SketchHistogram) Flush() HistogramResult {
+	return h.bounds.result(h.Quantile)
+}
+
+// Quantile returns the estimated value at quantile q
+func (h *SketchHistogram) Quantile(q float64) float64 {
+	return h.sketch.Quantile(q)
+}
+
+// Reset discards all data points
+func (h *SketchHistogram) Reset() {
+	h.sketch.Reset()
+	h.bounds = bounds{}
+}
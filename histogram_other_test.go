@@ -0,0 +1,90 @@
+package dogdirect
+
+import (
+	"fmt"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestOtherHistograms(t *testing.T) {
+	cases := []struct {
+		name string
+		hist Histogram
+		tol  float64 // relative, of percentiles
+	}{
+		{"exact", NewExactHistogram(0, nil), 0},
+		{"hdr", NewHDRHistogram(1, 100000, 3), 0.001},
+		{"streaming", NewStreamingHistogram(50), 0.05},
+		{"sketch", NewSketchHistogram(), SketchRelativeAccuracy},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			for i := 1000; i > 0; i-- {
+				tc.hist.Add(float64(i))
+			}
+			hr := tc.hist.Flush()
+			if hr.Count != 1000 || hr.Min != 1 || hr.Max != 1000 || hr.Sum != 500500 || hr.Avg != 500.5 {
+				t.Errorf("got %+v", hr)
+			}
+			check := func(what string, got, want float64) {
+				if math.Abs(got-want) > want*tc.tol+1 {
+					t.Errorf("%s: got %v, want %v", what, got, want)
+				}
+			}
+			check("median", hr.Median, 500)
+			check("p95", hr.P95, 950)
+			q, ok := tc.hist.(Quantiler)
+			if !ok {
+				t.Fatalf("%T is not a Quantiler", tc.hist)
+			}
+			check("p99", q.Quantile(0.99), 990)
+			check("p100", q.Quantile(1), 1000)
+
+			tc.hist.Reset()
+			if hr := tc.hist.Flush(); hr.Count != 0 {
+				t.Errorf("after Reset got %+v", hr)
+			}
+			tc.hist.Add(7)
+			if hr := tc.hist.Flush(); hr.Count != 1 || hr.Min != 7 || hr.Max != 7 || hr.Median != 7 {
+				t.Errorf("after Reset and Add got %+v", hr)
+			}
+		})
+	}
+}
+
+func TestHDRHistogramClamp(t *testing.T) {
+	h := NewHDRHistogram(1, 1000, 2)
+	h.Add(-5)
+	h.Add(5000)
+	hr := h.Flush()
+	if hr.Min != -5 || hr.Max != 5000 || hr.Sum != 4995 {
+		t.Errorf("got %+v", hr)
+	}
+}
+
+func TestHistogramFactory(t *testing.T) {
+	c := NewClient(WithHistogramFactory(func() Histogram {
+		return NewHDRHistogram(1, 60000, 3)
+	}))
+	for i := 1; i <= 100; i++ {
+		c.Timing("req", time.Duration(i)*time.Millisecond, nil)
+		c.Histogram("size", float64(i), nil)
+	}
+	want := map[string]string{
+		"req":  "*dogdirect.SketchHistogram",
+		"size": "*dogdirect.HDRHistogram",
+	}
+	for _, h := range c.histograms {
+		if got := fmt.Sprintf("%T", h.hist); got != want[h.name] {
+			t.Errorf("%s: got %s, want %s", h.name, got, want[h.name])
+		}
+	}
+	snap := c.Snapshot()
+	snap.finalize(c.now())
+	for _, m := range snap.Series {
+		if (m.Name == "size.max" || m.Name == "req.max") && m.Value[0][1] != 100 {
+			t.Errorf("%s: got %v", m.Name, m.Value[0][1])
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package dogdirect
 
 import (
+	"math"
 	"testing"
 )
 
@@ -25,3 +26,173 @@ func benchmarkExactHistogram(sz int, b *testing.B) {
 func BenchmarkExactHistogram1k(b *testing.B)   { benchmarkExactHistogram(1000, b) }
 func BenchmarkExactHistogram10k(b *testing.B)  { benchmarkExactHistogram(10000, b) }
 func BenchmarkExactHistogram100k(b *testing.B) { benchmarkExactHistogram(100000, b) }
+
+// benchmarkHistogram is like benchmarkExactHistogram, but reuses one
+// histogram, as the client would on a hot path
+func benchmarkHistogram(h Histogram, sz int, b *testing.B) {
+	b.ReportAllocs()
+	for n := 0; n < b.N; n++ {
+		for i := sz - 1; i >= 0; i-- {
+			h.Add(float64(i))
+		}
+		hr := h.Flush()
+		if int(hr.Count) != sz {
+			b.Fatalf("benchmark %T failed.  count Expected %d got %d", h, sz, int(hr.Count))
+		}
+		result = hr.Count
+		h.Reset()
+	}
+}
+
+func BenchmarkSketchHistogram1k(b *testing.B)   { benchmarkHistogram(NewSketchHistogram(), 1000, b) }
+func BenchmarkSketchHistogram10k(b *testing.B)  { benchmarkHistogram(NewSketchHistogram(), 10000, b) }
+func BenchmarkSketchHistogram100k(b *testing.B) { benchmarkHistogram(NewSketchHistogram(), 100000, b) }
+
+func BenchmarkHDRHistogram1k(b *testing.B) {
+	benchmarkHistogram(NewHDRHistogram(1, 100000, 3), 1000, b)
+}
+func BenchmarkHDRHistogram10k(b *testing.B) {
+	benchmarkHistogram(NewHDRHistogram(1, 100000, 3), 10000, b)
+}
+func BenchmarkHDRHistogram100k(b *testing.B) {
+	benchmarkHistogram(NewHDRHistogram(1, 100000, 3), 100000, b)
+}
+
+func BenchmarkStreamingHistogram1k(b *testing.B) {
+	benchmarkHistogram(NewStreamingHistogram(20), 1000, b)
+}
+func BenchmarkStreamingHistogram10k(b *testing.B) {
+	benchmarkHistogram(NewStreamingHistogram(20), 10000, b)
+}
+
+func TestHistogramConfig(t *testing.T) {
+	c := NewClient(
+		WithHistogramConfig(HistogramConfig{
+			Aggregates:  []string{AggregateMin, AggregateSum, AggregateCount},
+			Percentiles: []float64{0.75, 0.99, 0.999},
+		}),
+		WithHistogramConfigFor("special", HistogramConfig{
+			Aggregates: []string{AggregateMax},
+		}),
+		WithCountType(TypeCount),
+	)
+	for i := 1; i <= 1000; i++ {
+		c.Histogram("latency", float64(i), nil)
+		c.Histogram("special", float64(i), nil)
+	}
+	snap := c.Snapshot()
+	snap.finalize(c.now())
+
+	want := map[string]float64{
+		"latency.min":            1,
+		"latency.sum":            500500,
+		"latency.count":          1000,
+		"latency.75percentile":   750,
+		"latency.99percentile":   990,
+		"latency.99.9percentile": 999,
+		"special.max":            1000,
+	}
+	if len(snap.Series) != len(want) {
+		t.Errorf("got %d series, want %d", len(snap.Series), len(want))
+	}
+	for _, m := range snap.Series {
+		w, ok := want[m.Name]
+		if !ok {
+			t.Errorf("unexpected metric %q", m.Name)
+			continue
+		}
+		if m.Value[0][1] != w {
+			t.Errorf("%s: got %v, want %v", m.Name, m.Value[0][1], w)
+		}
+	}
+}
+
+func TestPercentileMethods(t *testing.T) {
+	// the samples of the nearest-rank example of
+	// https://en.wikipedia.org/wiki/Percentile, whose p5, p30, p40,
+	// p50 and p100 are given there; the rest are ceil(p*n).  agent is
+	// round(p*n-1), the agent's rounding, and linear is
+	// numpy.percentile(samples, p*100) with the default method.
+	samples := []float64{50, 15, 40, 20, 35}
+	cases := []struct {
+		p       float64
+		agent   float64
+		nearest float64
+		linear  float64
+	}{
+		{0, 15, 15, 15},
+		{0.05, 15, 15, 16},
+		{0.3, 20, 20, 23},
+		{0.4, 20, 20, 29},
+		{0.5, 35, 35, 35},
+		{0.65, 35, 40, 38},
+		{0.95, 50, 50, 48},
+		{1, 50, 50, 50},
+	}
+	for _, method := range []PercentileMethod{AgentRank, NearestRank, Linear} {
+		h := NewExactHistogram(0, nil)
+		h.SetMethod(method)
+		for _, v := range samples {
+			h.Add(v)
+		}
+		hr := h.Flush()
+		for _, tc := range cases {
+			want := tc.agent
+			switch method {
+			case NearestRank:
+				want = tc.nearest
+			case Linear:
+				want = tc.linear
+			}
+			if got := h.Quantile(tc.p); math.Abs(got-want) > 1e-9 {
+				t.Errorf("method %d, p%v: got %v, want %v", method, tc.p*100, got, want)
+			}
+		}
+		if hr.Median != 35 {
+			t.Errorf("method %d median: got %v, want 35", method, hr.Median)
+		}
+	}
+}
+
+func TestExactHistogramAgent(t *testing.T) {
+	// the agent's rounding, round(p*n-1): the median of an even count
+	// is the lower middle sample
+	cases := []struct {
+		samples []float64
+		median  float64
+		p95     float64
+	}{
+		{[]float64{7}, 7, 7},
+		{[]float64{1, 2}, 1, 2},
+		{[]float64{1, 2, 3, 4}, 2, 4},
+		{[]float64{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}, 5, 10},
+		{[]float64{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18, 19, 20}, 10, 19},
+	}
+	for _, tc := range cases {
+		h := NewExactHistogram(0, nil)
+		for _, v := range tc.samples {
+			h.Add(v)
+		}
+		hr := h.Flush()
+		if hr.Median != tc.median || hr.P95 != tc.p95 {
+			t.Errorf("%v: got median %v p95 %v, want %v and %v", tc.samples, hr.Median, hr.P95, tc.median, tc.p95)
+		}
+	}
+}
+
+func TestHistogramStdDev(t *testing.T) {
+	samples := []float64{2, 4, 4, 4, 5, 5, 7, 9}
+	for _, h := range []Histogram{
+		NewExactHistogram(0, nil),
+		NewHDRHistogram(1, 100, 3),
+		NewStreamingHistogram(4),
+		NewSketchHistogram(),
+	} {
+		for _, v := range samples {
+			h.Add(v)
+		}
+		if hr := h.Flush(); math.Abs(hr.StdDev-2) > 1e-9 || hr.Avg != 5 {
+			t.Errorf("%T: got stddev %v avg %v, want 2 and 5", h, hr.StdDev, hr.Avg)
+		}
+	}
+}
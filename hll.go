@@ -0,0 +1,90 @@
+package dogdirect
+
+import (
+	"hash/fnv"
+	"math"
+	"math/bits"
+)
+
+// hyperLogLog estimates the number of distinct values in constant
+// memory: 2^precision bytes, with a standard error of about
+// 1.04/sqrt(2^precision).  Precision 14 is 16KB and 0.8% error.
+//
+// See Flajolet et al, "HyperLogLog: the analysis of a near-optimal
+// cardinality estimation algorithm" (2007).
+type hyperLogLog struct {
+	p         uint8
+	registers []uint8
+}
+
+// newHyperLogLog creates an estimator, with precision between 4 and 16
+func newHyperLogLog(precision uint8) *hyperLogLog {
+	if precision < 4 {
+		precision = 4
+	}
+	if precision > 16 {
+		precision = 16
+	}
+	return &hyperLogLog{
+		p:         precision,
+		registers: make([]uint8, 1<<precision),
+	}
+}
+
+// hash64 is FNV-1a with a final mix, since FNV alone doesn't spread
+// short strings well enough over the high bits
+func hash64(s string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(s))
+	x := h.Sum64()
+	// splitmix64 finalizer
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
+
+// Add adds a value
+func (h *hyperLogLog) Add(value string) {
+	x := hash64(value)
+	idx := x >> (64 - h.p)
+	// position of the first 1 bit in the rest, the guard bit
+	// limits it to 64-p+1
+	rho := uint8(bits.LeadingZeros64(x<<h.p|1<<(h.p-1))) + 1
+	if rho > h.registers[idx] {
+		h.registers[idx] = rho
+	}
+}
+
+// Count returns the estimated number of distinct values
+func (h *hyperLogLog) Count() float64 {
+	m := float64(len(h.registers))
+	sum, zeros := 0.0, 0
+	for _, r := range h.registers {
+		sum += math.Ldexp(1, -int(r))
+		if r == 0 {
+			zeros++
+		}
+	}
+
+	var alpha float64
+	switch len(h.registers) {
+	case 16:
+		alpha = 0.673
+	case 32:
+		alpha = 0.697
+	case 64:
+		alpha = 0.709
+	default:
+		alpha = 0.7213 / (1 + 1.079/m)
+	}
+	estimate := alpha * m * m / sum
+
+	// small range correction, linear counting
+	if estimate <= 2.5*m && zeros != 0 {
+		return math.Round(m * math.Log(m/float64(zeros)))
+	}
+	return math.Round(estimate)
+}
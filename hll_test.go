@@ -0,0 +1,30 @@
+package dogdirect
+
+import (
+	"math"
+	"strconv"
+	"testing"
+)
+
+func TestHyperLogLog(t *testing.T) {
+	for _, n := range []int{0, 1, 10, 1000, 100000} {
+		h := newHyperLogLog(14)
+		for i := 0; i < n; i++ {
+			v := "user" + strconv.Itoa(i)
+			// duplicates don't count
+			h.Add(v)
+			h.Add(v)
+		}
+		got := h.Count()
+		if n == 0 {
+			if got != 0 {
+				t.Errorf("n=0: got %v", got)
+			}
+			continue
+		}
+		// 4 standard errors
+		if err := math.Abs(got-float64(n)) / float64(n); err > 4*0.0081 {
+			t.Errorf("n=%d: got %v, error %.2f%%", n, got, err*100)
+		}
+	}
+}
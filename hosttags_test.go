@@ -33,8 +33,7 @@ func TestHostTagger(t *testing.T) {
 	}))
 	defer ts.Close()
 
-	endpointv1 = ts.URL
-	api := NewAPI("foo", "bar", 0)
+	api := NewAPI("foo", "bar", 0, WithEndpoint(ts.URL))
 
 	for _, tt := range hostTaggerCases {
 		ht := NewHostTagger(api, tt.hostname, tt.tags)
@@ -1,6 +1,11 @@
 package dogdirect
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
 	"sync"
 	"time"
 )
@@ -48,14 +53,20 @@ const (
 // Metric is a data structure that represents the JSON that Datadog
 // wants when posting to the API
 type Metric struct {
-	Name     string        `json:"metric"`
-	Value    [1][2]float64 `json:"points"`
-	Type     string        `json:"type"`
-	Hostname string        `json:"host,omitempty"`
-	Tags     []string      `json:"tags,omitempty"`
-	Interval int           `json:"interval,omitempty"`
+	Name     string       `json:"metric"`
+	Value    [][2]float64 `json:"points"`
+	Type     string       `json:"type"`
+	Hostname string       `json:"host,omitempty"`
+	Tags     []string     `json:"tags,omitempty"`
+	Interval int          `json:"interval,omitempty"`
+	Unit     string       `json:"-"` // only sent with the v2 API
 }
 
+var (
+	errNoSink       = errors.New("no sink configured")
+	errNoSketchSink = errors.New("sink does not support distributions")
+)
+
 func now() float64 {
 	return float64(time.Now().Unix())
 }
@@ -69,63 +80,271 @@ func NewMetric(name string, mtype string, tags []string) *Metric {
 	}
 }
 
+// contextKey returns the lookup key for a metric name and tag set, along
+// with the normalized (sorted and deduplicated) tags.  Each distinct
+// combination of name and tags is its own series.
+//
+// The caller's slice is copied, not modified.
+func contextKey(name string, tags []string) (string, []string) {
+	if len(tags) == 0 {
+		return name, nil
+	}
+	tags = unique(append([]string(nil), tags...))
+	return name + "\x00" + strings.Join(tags, "\x00"), tags
+}
+
+// NoHost is a tag that submits a metric without any hostname, for
+// metrics that are not specific to a host.
+const NoHost = "host:"
+
+// hostTag extracts the magic "host:" tag, which overrides the hostname
+// of a metric and is removed from the tag list.  An empty value means
+// no hostname.  The input slice is modified.
+func hostTag(tags []string) (string, bool, []string) {
+	host, found := "", false
+	j := 0
+	for _, tag := range tags {
+		if strings.HasPrefix(tag, NoHost) {
+			host, found = tag[len(NoHost):], true
+			continue
+		}
+		tags[j] = tag
+		j++
+	}
+	if j == 0 {
+		return host, found, nil
+	}
+	return host, found, tags[:j]
+}
+
+// histogramContext is a histogram plus the name and tags it reports as
+type histogramContext struct {
+	name string
+	tags []string
+	hist Histogram
+}
+
 // Client is the main datastructure of metrics to upload
 type Client struct {
 	Series     []*Metric          `json:"series"` // raw data
 	hostname   string             // hostname
+	namespace  string             // global namespace prefix, if any
 	tags       []string           // global tags, if any
-	metrics    map[string]*Metric // map of name to metric for fast lookup
-	histograms map[string]*ExactHistogram
+	units      map[string]string  // metric name to unit, if any
+	metrics    map[string]*Metric // map of context key to metric for fast lookup
+	histograms map[string]*histogramContext
+	sets       map[string]*setContext
 	now        func() float64 // for testing
-	writer     API            // where output goes
+	sink       Sink           // where output goes
+	spool      *Spool         // failed metrics, if any
+	retries    *retryBuffer   // failed metrics, if any
 	lastFlush  float64        // unix epoch as float64(t.Now().Unix())
 
+	newHistogram  func() Histogram
+	newTiming     func() Histogram
+	flushInterval time.Duration
+	bucket        float64 // seconds, 0 is not bucketed
+	setPrecision  uint8   // of approximate sets, 0 is exact
+	distributions map[string]*distributionContext
+	sketches      []*SketchSeries // distributions, once finalized
+	countType     string          // TypeRate or TypeCount
+	sent          map[sentPoint]struct{}
+	histConfig    HistogramConfig
+	histConfigs   map[string]HistogramConfig // by histogram name
+
 	sync.Mutex
 }
 
-// New creates a new datadog metrics client
-func New(hostname string, api API) *Client {
+// DefaultFlushInterval is how often a client is expected to be flushed
+const DefaultFlushInterval = 15 * time.Second
+
+// New creates a new datadog metrics client that writes to api
+func New(hostname string, api API, opts ...Option) *Client {
+	return NewClient(append([]Option{WithHostname(hostname), WithSink(api)}, opts...)...)
+}
+
+// NewClient creates a new datadog metrics client configured by opts.
+// A sink must be provided with WithSink for Flush to send anything.
+func NewClient(opts ...Option) *Client {
 	client := &Client{
-		now:        now,
-		hostname:   hostname,
-		metrics:    make(map[string]*Metric),
-		histograms: make(map[string]*ExactHistogram),
-		writer:     api,
-		lastFlush:  now(),
+		now:           now,
+		metrics:       make(map[string]*Metric),
+		histograms:    make(map[string]*histogramContext),
+		sets:          make(map[string]*setContext),
+		distributions: make(map[string]*distributionContext),
+		newHistogram:  newExactHistogram,
+		newTiming:     newSketchHistogram,
+		flushInterval: DefaultFlushInterval,
+		countType:     TypeRate,
+		histConfig:    DefaultHistogramConfig,
+	}
+	for _, opt := range opts {
+		opt(client)
 	}
+	client.lastFlush = client.now()
 	return client
 }
 
-// Gauge represents an observation
-func (c *Client) Gauge(name string, value float64, tags []string) error {
-	c.Lock()
-	m, ok := c.metrics[name]
+// FlushInterval returns how often the client expects to be flushed,
+// for use with NewPeriodic
+func (c *Client) FlushInterval() time.Duration {
+	return c.flushInterval
+}
+
+// newMetric creates a metric using the client hostname, unless
+// overridden by a "host:" tag.  Not locked.
+func (c *Client) newMetric(name string, mtype string, tags []string) *Metric {
+	host, found, tags := hostTag(tags)
+	if !found {
+		host = c.hostname
+	}
+	m := NewMetric(name, mtype, tags)
+	m.Hostname = host
+	return m
+}
+
+// point returns the point with timestamp ts, adding it if needed.
+// A timestamp of 0 is set to the flush time.
+func (m *Metric) point(ts float64) *[2]float64 {
+	// points are added in time order, so look from the end
+	for i := len(m.Value) - 1; i >= 0; i-- {
+		if m.Value[i][0] == ts {
+			return &m.Value[i]
+		}
+	}
+	m.Value = append(m.Value, [2]float64{ts, 0})
+	return &m.Value[len(m.Value)-1]
+}
+
+// timestamp returns the timestamp of a point recorded now: the start
+// of the current bucket if bucketing, or 0 for the flush time.
+// Not locked.
+func (c *Client) timestamp() float64 {
+	if c.bucket <= 0 {
+		return 0
+	}
+	now := c.now()
+	return now - math.Mod(now, c.bucket)
+}
+
+// record adds a value to a metric, either summing (for counts and
+// rates) or replacing (for gauges).  Not locked.
+func (c *Client) record(name string, mtype string, tags []string, ts float64, value float64) {
+	key, tags := contextKey(name, tags)
+	m, ok := c.metrics[key]
 	if !ok {
-		m = NewMetric(name, TypeGauge, unique(tags))
+		m = c.newMetric(name, mtype, tags)
 		c.Series = append(c.Series, m)
-		c.metrics[name] = m
+		c.metrics[key] = m
+	}
+	p := m.point(ts)
+	if m.Type == TypeGauge {
+		p[1] = value
+	} else {
+		// note, a rate must be divided by the interval length
+		//  before sending.
+		p[1] += value
 	}
-	m.Value[0][1] = value
+}
+
+// Gauge represents an observation.
+//
+// A "host:name" tag submits the metric as coming from that host
+// instead of the client hostname; see also NoHost.
+func (c *Client) Gauge(name string, value float64, tags []string) error {
+	c.Lock()
+	c.record(name, TypeGauge, tags, c.timestamp(), value)
 	c.Unlock()
 	return nil
 }
 
-// Count represents a count of events
+// Count represents a count of events.  It is sent as a rate, the
+// count per second, unless the client was created with
+// WithCountType(TypeCount).
 func (c *Client) Count(name string, value float64, tags []string) error {
 	c.Lock()
-	m, ok := c.metrics[name]
-	if !ok {
-		m = NewMetric(name, TypeRate, unique(tags))
-		c.Series = append(c.Series, m)
-		c.metrics[name] = m
-	}
-	// note, this sum must be divided by the interval length
-	//  before sending.
-	m.Value[0][1] += value
+	c.record(name, c.countType, tags, c.timestamp(), value)
 	c.Unlock()
 	return nil
 }
 
+// Rate represents a count of events that is always sent as a rate,
+// the count per second over the flush interval
+func (c *Client) Rate(name string, value float64, tags []string) error {
+	c.Lock()
+	c.record(name, TypeRate, tags, c.timestamp(), value)
+	c.Unlock()
+	return nil
+}
+
+// Datadog only accepts points in this window around the current time
+const (
+	maxPast   = time.Hour
+	maxFuture = 10 * time.Minute
+)
+
+// Errors from recording a point outside of the window Datadog accepts
+var (
+	ErrTooOld    = errors.New("timestamp more than 1 hour in the past")
+	ErrTooFuture = errors.New("timestamp more than 10 minutes in the future")
+)
+
+// ErrAlreadySent is from CountAt when a count at the same timestamp
+// (or in the same bucket) was already sent.  Datadog keeps only the
+// last value at a timestamp, so sending another would replace it.
+var ErrAlreadySent = errors.New("count already sent at this timestamp")
+
+// timestampAt returns the timestamp of a point recorded for t,
+// checking that Datadog will accept it.  Not locked.
+func (c *Client) timestampAt(t time.Time) (float64, error) {
+	ts := float64(t.Unix())
+	now := c.now()
+	switch {
+	case ts < now-maxPast.Seconds():
+		return 0, ErrTooOld
+	case ts > now+maxFuture.Seconds():
+		return 0, ErrTooFuture
+	}
+	if c.bucket > 0 {
+		ts -= math.Mod(ts, c.bucket)
+	}
+	return ts, nil
+}
+
+// GaugeAt is an observation at time t, instead of the flush time.
+// t must be within an hour in the past, or 10 minutes in the future.
+func (c *Client) GaugeAt(name string, value float64, tags []string, t time.Time) error {
+	c.Lock()
+	defer c.Unlock()
+	ts, err := c.timestampAt(t)
+	if err != nil {
+		return err
+	}
+	c.record(name, TypeGauge, tags, ts, value)
+	return nil
+}
+
+// CountAt is a count of events at time t, instead of the flush time.
+// t must be within an hour in the past, or 10 minutes in the future.
+// Like Count, it is sent as a rate over the flush interval (or the
+// bucket size if bucketing), unless the count type is TypeCount.  If
+// a count of the same name and tags was already sent at that
+// timestamp, or in that bucket, it returns ErrAlreadySent.
+func (c *Client) CountAt(name string, value float64, tags []string, t time.Time) error {
+	c.Lock()
+	defer c.Unlock()
+	ts, err := c.timestampAt(t)
+	if err != nil {
+		return err
+	}
+	key, _ := contextKey(name, tags)
+	if _, ok := c.sent[sentPoint{key, ts}]; ok {
+		return ErrAlreadySent
+	}
+	c.record(name, c.countType, tags, ts, value)
+	return nil
+}
+
 // Incr adds one event count, same as Count(name, 1)
 func (c *Client) Incr(name string, tags []string) error {
 	return c.Count(name, 1.0, tags)
@@ -136,93 +355,373 @@ func (c *Client) Decr(name string, tags []string) error {
 	return c.Count(name, -1.0, tags)
 }
 
-// Timing records a duration
+// Timing records a duration, as a histogram in milliseconds.  By
+// default timings use a SketchHistogram, so memory is bounded however
+// many are recorded; see WithTimingFactory.
 func (c *Client) Timing(name string, val time.Duration, tags []string) error {
 	// datadog works in milliseconds
-	return c.Histogram(name, val.Seconds()*1000, tags)
+	return c.histogram(name, val.Seconds()*1000, tags, c.newTiming)
 }
 
 // Histogram records a value that will be used in aggregate
 func (c *Client) Histogram(name string, val float64, tags []string) error {
+	return c.histogram(name, val, tags, c.newHistogram)
+}
+
+// histogram adds a value to the histogram of a context, creating it
+// with factory if it is new
+func (c *Client) histogram(name string, val float64, tags []string, factory func() Histogram) error {
+	key, tags := contextKey(name, tags)
 	c.Lock()
-	h := c.histograms[name]
+	h := c.histograms[key]
 	if h == nil {
-		h = NewExactHistogram(1000, tags)
-		c.histograms[name] = h
+		h = &histogramContext{
+			name: name,
+			tags: tags,
+			hist: factory(),
+		}
+		c.histograms[key] = h
+	}
+	h.hist.Add(val)
+	c.Unlock()
+	return nil
+}
+
+// setContext is the distinct members of a set, plus the name and
+// tags it reports as.  Exact sets use members, approximate ones hll.
+type setContext struct {
+	name    string
+	tags    []string
+	members map[string]struct{}
+	hll     *hyperLogLog
+}
+
+// Set counts distinct values, such as unique users, in each flush
+// interval.  The number of distinct values is sent as a gauge.  See
+// WithApproximateSets to bound memory for sets with many values.
+func (c *Client) Set(name string, value string, tags []string) error {
+	key, tags := contextKey(name, tags)
+	c.Lock()
+	s := c.sets[key]
+	if s == nil {
+		s = &setContext{
+			name: name,
+			tags: tags,
+		}
+		if c.setPrecision != 0 {
+			s.hll = newHyperLogLog(c.setPrecision)
+		} else {
+			s.members = make(map[string]struct{})
+		}
+		c.sets[key] = s
+	}
+	if s.hll != nil {
+		s.hll.Add(value)
+	} else {
+		s.members[value] = struct{}{}
 	}
-	h.Add(val)
 	c.Unlock()
 	return nil
 }
 
-// Snapshot makes a copy of the data and resets everything locally
+// distributionContext is a sketch plus the name, host and tags it
+// reports as
+type distributionContext struct {
+	name   string
+	host   string
+	tags   []string
+	sketch *Sketch
+}
+
+// Distribution records a value whose percentiles are computed by
+// Datadog across all hosts, unlike Histogram which computes them
+// locally.  Values are kept in a Sketch, and submitted to the sink
+// only if it is a SketchSink, such as API.
+func (c *Client) Distribution(name string, val float64, tags []string) error {
+	key, tags := contextKey(name, tags)
+	c.Lock()
+	d := c.distributions[key]
+	if d == nil {
+		host, found, tags := hostTag(append([]string(nil), tags...))
+		if !found {
+			host = c.hostname
+		}
+		d = &distributionContext{
+			name:   name,
+			host:   host,
+			tags:   tags,
+			sketch: NewSketch(),
+		}
+		c.distributions[key] = d
+	}
+	d.sketch.Add(val)
+	c.Unlock()
+	return nil
+}
+
+// Snapshot makes a copy of the data and resets everything locally.
+// When bucketing, points in buckets that have not ended yet stay,
+// so a bucket is only sent once it is complete.
 func (c *Client) Snapshot() *Client {
+	return c.snapshot(false)
+}
+
+// snapshot is Snapshot, but with all true it takes every bucket,
+// ended or not
+func (c *Client) snapshot(all bool) *Client {
 	c.Lock()
+	now := c.now()
 	defer func() {
-		c.lastFlush = c.now()
+		c.lastFlush = now
 		c.Unlock()
 	}()
 
-	if len(c.Series) == 0 && len(c.histograms) == 0 {
+	if len(c.Series) == 0 && len(c.histograms) == 0 && len(c.sets) == 0 && len(c.distributions) == 0 {
 		return nil
 	}
 	snap := Client{
 		hostname:   c.hostname,
+		namespace:  c.namespace,
+		tags:       c.tags,
+		units:      c.units,
+		countType:  c.countType,
+		bucket:     c.bucket,
 		Series:     c.Series,
 		metrics:    c.metrics,
 		histograms: c.histograms,
+		sets:       c.sets,
 		lastFlush:  c.lastFlush,
+
+		distributions: c.distributions,
+		histConfig:    c.histConfig,
+		histConfigs:   c.histConfigs,
 	}
 	c.metrics = make(map[string]*Metric)
-	c.histograms = make(map[string]*ExactHistogram)
+	c.histograms = make(map[string]*histogramContext)
+	c.sets = make(map[string]*setContext)
+	c.distributions = make(map[string]*distributionContext)
 	c.Series = nil
+	if c.bucket > 0 && !all {
+		c.keepOpenBuckets(&snap, now)
+	}
+	c.markSent(&snap, now)
 	return &snap
 }
 
+// sentPoint is the context key and timestamp of a count sent
+type sentPoint struct {
+	key string
+	ts  float64
+}
+
+// markSent remembers the timestamps of the counts in a snapshot, for
+// CountAt, forgetting those too old to matter.  Points without a
+// timestamp are sent at now.  Must hold lock.
+func (c *Client) markSent(snap *Client, now float64) {
+	cutoff := now - maxPast.Seconds()
+	for p := range c.sent {
+		if p.ts < cutoff {
+			delete(c.sent, p)
+		}
+	}
+	for key, m := range snap.metrics {
+		if m.Type != TypeCount && m.Type != TypeRate {
+			continue
+		}
+		if c.sent == nil {
+			c.sent = make(map[sentPoint]struct{})
+		}
+		for _, p := range m.Value {
+			ts := p[0]
+			if ts == 0 {
+				ts = now
+			}
+			if ts >= cutoff {
+				c.sent[sentPoint{key, ts}] = struct{}{}
+			}
+		}
+	}
+}
+
+// keepOpenBuckets moves the points of buckets that end after now
+// from a snapshot back to the client.  Otherwise the rest of the
+// bucket would be sent later with the same timestamp, and Datadog
+// keeps only the last value sent.  Must hold lock.
+func (c *Client) keepOpenBuckets(snap *Client, now float64) {
+	series := snap.Series[:0]
+	for key, m := range snap.metrics {
+		var closed, open [][2]float64
+		for _, p := range m.Value {
+			if p[0] != 0 && p[0]+c.bucket > now {
+				open = append(open, p)
+			} else {
+				closed = append(closed, p)
+			}
+		}
+		if len(open) == 0 {
+			continue
+		}
+		kept := *m
+		kept.Value = open
+		c.metrics[key] = &kept
+		c.Series = append(c.Series, &kept)
+		m.Value = closed
+	}
+	for _, m := range snap.Series {
+		if len(m.Value) != 0 {
+			series = append(series, m)
+		}
+	}
+	snap.Series = series
+}
+
+// withGlobalTags adds the global tags to tags
+func (c *Client) withGlobalTags(tags []string) []string {
+	if len(c.tags) == 0 {
+		return tags
+	}
+	out := make([]string, 0, len(tags)+len(c.tags))
+	out = append(out, tags...)
+	return unique(append(out, c.tags...))
+}
+
 // not locked.. for use locally with snapshots
 func (c *Client) finalize(nowUnix float64) {
 	interval := nowUnix - c.lastFlush
 
 	// histograms: convert to various descriptive statistic gauges
-	for name, h := range c.histograms {
-		hr := h.Flush()
-		if hr.Count == 0 {
-			continue
+	for _, h := range c.histograms {
+		c.flushHistogram(h)
+	}
+	// distributions: sent as is, also not bucketed
+	for _, d := range c.distributions {
+		c.sketches = append(c.sketches, &SketchSeries{
+			Name:      c.namespace + d.name,
+			Host:      d.host,
+			Tags:      c.withGlobalTags(d.tags),
+			Timestamp: int64(nowUnix),
+			Sketch:    d.sketch,
+		})
+	}
+
+	// sets: the number of distinct members, also not bucketed
+	for _, s := range c.sets {
+		count := float64(len(s.members))
+		if s.hll != nil {
+			count = s.hll.Count()
 		}
-		c.Count(name+".count", hr.Count, h.tags)
-		c.Gauge(name+".max", hr.Max, h.tags)
-		c.Gauge(name+".avg", hr.Avg, h.tags)
-		c.Gauge(name+".median", hr.Median, h.tags)
-		c.Gauge(name+".95percentile", hr.P95, h.tags)
+		c.record(s.name, TypeGauge, s.tags, 0, count)
 	}
 	for i := 0; i < len(c.Series); i++ {
-		c.Series[i].Value[0][0] = nowUnix
-		c.Series[i].Hostname = c.hostname
+		c.Series[i].Unit = c.units[c.Series[i].Name]
+		c.Series[i].Name = c.namespace + c.Series[i].Name
+		c.Series[i].Tags = c.withGlobalTags(c.Series[i].Tags)
 		c.Series[i].Interval = int(interval)
-		if c.Series[i].Type == "rate" {
-			c.Series[i].Value[0][1] /= interval
+		for j := range c.Series[i].Value {
+			p := &c.Series[i].Value[j]
+			span := interval
+			if p[0] == 0 {
+				p[0] = nowUnix
+			} else if c.bucket > 0 {
+				span = c.bucket
+				c.Series[i].Interval = int(c.bucket)
+			}
+			if c.Series[i].Type == TypeRate {
+				// flushed within a second, don't divide by zero
+				p[1] /= math.Max(span, 1)
+			}
 		}
 	}
 }
 
 // Flush forces a flush of the pending commands in the buffer
 func (c *Client) Flush() error {
+	return c.FlushContext(context.Background())
+}
+
+// FlushContext is Flush, but submissions are abandoned if ctx is
+// done first
+func (c *Client) FlushContext(ctx context.Context) error {
+	return c.flush(ctx, false)
+}
+
+// flush sends a snapshot, with all true including buckets that have
+// not ended yet
+func (c *Client) flush(ctx context.Context, all bool) error {
 	if c == nil {
 		return nil
 	}
-	snap := c.Snapshot()
-	if snap == nil {
-		return nil
+	var series []*Metric
+	var sketches []*SketchSeries
+	snap := c.snapshot(all)
+	if snap != nil {
+		// c.lastFlush is "now"
+		snap.finalize(c.lastFlush)
+		series = snap.Series
+		sketches = snap.sketches
+	}
+	if c.retries != nil {
+		series = append(c.retries.take(c.now()), series...)
+	}
+	if len(series) == 0 && len(sketches) == 0 {
+		// nothing new, but maybe something old
+		return c.replay(ctx)
 	}
 
-	// c.lastFlush is "now"
-	snap.finalize(c.lastFlush)
+	if c.sink == nil {
+		return errNoSink
+	}
+	var errout error
+	if len(series) != 0 {
+		if err := c.sink.Submit(ctx, series); err != nil {
+			var rerr error
+			if ctx.Err() != nil {
+				// abandoned, most likely at shutdown, so whatever
+				// the error, keep all that failed
+				rerr = c.abandon(FailedMetrics(err, series))
+			} else {
+				rerr = c.retain(retryable(err, series))
+			}
+			if rerr != nil {
+				err = fmt.Errorf("%v (spool failed: %v)", err, rerr)
+			}
+			errout = err
+		}
+	}
+	if len(sketches) != 0 {
+		// distributions are not retried or spooled
+		var err error
+		if ss, ok := c.sink.(SketchSink); ok {
+			err = ss.SubmitSketches(ctx, sketches)
+		} else {
+			err = errNoSketchSink
+		}
+		if err != nil && errout == nil {
+			errout = err
+		}
+	}
+	if errout != nil {
+		return errout
+	}
+	return c.replay(ctx)
+}
 
-	return c.writer.AddPoints(snap.Series)
+// replay sends any spooled metrics
+func (c *Client) replay(ctx context.Context) error {
+	if c.spool == nil || c.sink == nil {
+		return nil
+	}
+	return c.spool.Replay(ctx, c.sink)
 }
 
 // Close the client connection.
 func (c *Client) Close() error {
+	return c.CloseContext(context.Background())
+}
+
+// CloseContext is Close, but submissions are abandoned if ctx is done
+// first.  Buckets that have not ended yet are sent too.
+func (c *Client) CloseContext(ctx context.Context) error {
 	// make best attempt at closing writer
-	return c.Flush()
+	return c.flush(ctx, true)
 }
@@ -1,11 +1,13 @@
 package dogdirect
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 )
@@ -27,8 +29,7 @@ func TestBasicTest(t *testing.T) {
 	}))
 	defer ts.Close()
 
-	endpointv1 = ts.URL
-	api := NewAPI("foo", "bar", 0)
+	api := NewAPI("foo", "bar", 0, WithEndpoint(ts.URL))
 	c := New("hostname", api)
 	c.now = func() float64 {
 		return float64(time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC).Unix())
@@ -66,3 +67,368 @@ func TestBasicTest(t *testing.T) {
 		t.Fatalf("c.Flush(): %v", err)
 	}
 }
+
+func TestTagContexts(t *testing.T) {
+	c := New("hostname", API{})
+
+	c.Incr("http.requests", []string{"status:200"})
+	c.Incr("http.requests", []string{"status:500"})
+	c.Incr("http.requests", []string{"status:200"})
+	c.Incr("http.requests", []string{"status:200", "status:200"})
+	c.Gauge("queue.depth", 3, []string{"b", "a"})
+	c.Gauge("queue.depth", 5, []string{"a", "b"})
+
+	want := map[string]float64{
+		"http.requests status:200": 3,
+		"http.requests status:500": 1,
+		"queue.depth a,b":          5,
+	}
+	if len(c.Series) != len(want) {
+		t.Fatalf("got %d series, want %d", len(c.Series), len(want))
+	}
+	for _, m := range c.Series {
+		id := m.Name + " " + strings.Join(m.Tags, ",")
+		if got := m.Value[0][1]; got != want[id] {
+			t.Errorf("%s: got %v, want %v", id, got, want[id])
+		}
+	}
+}
+
+func TestContextKeyNoMutate(t *testing.T) {
+	tags := []string{"b", "a", "b"}
+	contextKey("name", tags)
+	if strings.Join(tags, ",") != "b,a,b" {
+		t.Errorf("caller tags modified: %v", tags)
+	}
+}
+
+func TestNamespaceAndGlobalTags(t *testing.T) {
+	c := New("hostname", API{}, WithNamespace("app"), WithTags("env:prod", "role:web"))
+
+	c.Gauge("foo", 1, []string{"role:web", "zone:a"})
+	c.Histogram("bar", 1, nil)
+	snap := c.Snapshot()
+	snap.finalize(c.now())
+
+	want := map[string]string{
+		"app.foo":              "env:prod,role:web,zone:a",
+		"app.bar.count":        "env:prod,role:web",
+		"app.bar.max":          "env:prod,role:web",
+		"app.bar.avg":          "env:prod,role:web",
+		"app.bar.median":       "env:prod,role:web",
+		"app.bar.95percentile": "env:prod,role:web",
+	}
+	if len(snap.Series) != len(want) {
+		t.Fatalf("got %d series, want %d", len(snap.Series), len(want))
+	}
+	for _, m := range snap.Series {
+		tags, ok := want[m.Name]
+		if !ok {
+			t.Errorf("unexpected metric %q", m.Name)
+			continue
+		}
+		if got := strings.Join(m.Tags, ","); got != tags {
+			t.Errorf("%s: got tags %q, want %q", m.Name, got, tags)
+		}
+	}
+}
+
+func TestHostTag(t *testing.T) {
+	c := New("hostname", API{})
+
+	c.Gauge("foo", 1, nil)
+	c.Gauge("foo", 2, []string{"host:db-7", "role:db"})
+	c.Gauge("foo", 3, []string{"host:db-8", "role:db"})
+	c.Gauge("foo", 4, []string{NoHost})
+	c.Histogram("bar", 1, []string{"host:db-7"})
+	snap := c.Snapshot()
+	snap.finalize(snap.lastFlush + 1)
+
+	want := map[string]string{
+		"foo 1":              "hostname",
+		"foo 2 role:db":      "db-7",
+		"foo 3 role:db":      "db-8",
+		"foo 4":              "",
+		"bar.count 1":        "db-7",
+		"bar.max 1":          "db-7",
+		"bar.avg 1":          "db-7",
+		"bar.median 1":       "db-7",
+		"bar.95percentile 1": "db-7",
+	}
+	if len(snap.Series) != len(want) {
+		t.Fatalf("got %d series, want %d", len(snap.Series), len(want))
+	}
+	for _, m := range snap.Series {
+		id := strings.TrimSpace(fmt.Sprintf("%s %v %s", m.Name, m.Value[0][1], strings.Join(m.Tags, ",")))
+		host, ok := want[id]
+		if !ok {
+			t.Errorf("unexpected metric %q", id)
+			continue
+		}
+		if m.Hostname != host {
+			t.Errorf("%s: got host %q, want %q", id, m.Hostname, host)
+		}
+	}
+}
+
+func TestSink(t *testing.T) {
+	var got []*Metric
+	sink := SinkFunc(func(ctx context.Context, metrics []*Metric) error {
+		got = metrics
+		return nil
+	})
+	clock := func() time.Time {
+		return time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	}
+	c := NewClient(WithHostname("hostname"), WithSink(sink), WithClock(clock))
+
+	c.Gauge("foobar", 123.4, nil)
+	if err := c.Flush(); err != nil {
+		t.Fatalf("c.Flush(): %v", err)
+	}
+	raw, err := json.Marshal(got)
+	if err != nil {
+		t.Fatalf("can't marshal: %s", err)
+	}
+	want := `[{"metric":"foobar","points":[[1640995200,123.4]],"type":"gauge","host":"hostname"}]`
+	if string(raw) != want {
+		t.Errorf("got %s, want %s", raw, want)
+	}
+
+	c = NewClient()
+	c.Gauge("foobar", 123.4, nil)
+	if err := c.Flush(); err != errNoSink {
+		t.Errorf("c.Flush() with no sink: got %v, want %v", err, errNoSink)
+	}
+}
+
+func TestFlushContext(t *testing.T) {
+	sink := SinkFunc(func(ctx context.Context, metrics []*Metric) error {
+		return ctx.Err()
+	})
+	c := NewClient(WithSink(sink))
+	c.Gauge("foobar", 1, nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := c.FlushContext(ctx); err != context.Canceled {
+		t.Errorf("c.FlushContext() with cancelled context: got %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestBuckets(t *testing.T) {
+	start := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	now := start
+	clock := func() time.Time { return now }
+	var got []*Metric
+	sink := SinkFunc(func(ctx context.Context, metrics []*Metric) error {
+		got = metrics
+		return nil
+	})
+	c := NewClient(WithHostname("hostname"), WithSink(sink), WithClock(clock), WithBuckets(10*time.Second))
+
+	for _, offset := range []time.Duration{1, 5, 12} {
+		now = start.Add(offset * time.Second)
+		c.Count("requests", 10, nil)
+		c.Gauge("depth", float64(offset), nil)
+	}
+	c.Histogram("latency", 1, nil)
+	now = start.Add(15 * time.Second)
+	if err := c.Flush(); err != nil {
+		t.Fatalf("c.Flush(): %v", err)
+	}
+
+	// the bucket at t0+10 has not ended, so is kept for the next flush
+	t0 := float64(start.Unix())
+	checkPoints(t, got, map[string]string{
+		"requests":      fmt.Sprint([][2]float64{{t0, 2}}),
+		"depth":         fmt.Sprint([][2]float64{{t0, 5}}),
+		"latency.count": fmt.Sprint([][2]float64{{t0 + 15, 1.0 / 15}}),
+	})
+
+	// the rest of the bucket, and a flush in the middle of the next
+	now = start.Add(17 * time.Second)
+	c.Count("requests", 3, nil)
+	c.Gauge("depth", 17, nil)
+	now = start.Add(25 * time.Second)
+	c.Count("requests", 5, nil)
+	if err := c.Flush(); err != nil {
+		t.Fatalf("c.Flush(): %v", err)
+	}
+	checkPoints(t, got, map[string]string{
+		"requests": fmt.Sprint([][2]float64{{t0 + 10, 1.3}}),
+		"depth":    fmt.Sprint([][2]float64{{t0 + 10, 17}}),
+	})
+
+	// closing sends buckets that have not ended
+	if err := c.Close(); err != nil {
+		t.Fatalf("c.Close(): %v", err)
+	}
+	checkPoints(t, got, map[string]string{
+		"requests": fmt.Sprint([][2]float64{{t0 + 20, 0.5}}),
+	})
+}
+
+// checkPoints checks the points of metrics by name, as printed
+func checkPoints(t *testing.T, got []*Metric, want map[string]string) {
+	t.Helper()
+	seen := make(map[string]bool)
+	for _, m := range got {
+		if seen[m.Name] {
+			t.Errorf("%s: sent twice", m.Name)
+		}
+		seen[m.Name] = true
+		w, ok := want[m.Name]
+		if !ok {
+			continue
+		}
+		if fmt.Sprint(m.Value) != w {
+			t.Errorf("%s: got points %v, want %s", m.Name, m.Value, w)
+		}
+		delete(want, m.Name)
+	}
+	if len(want) != 0 {
+		t.Errorf("missing metrics %v", want)
+	}
+}
+
+func TestRecordAt(t *testing.T) {
+	now := time.Date(2022, 1, 1, 12, 0, 0, 0, time.UTC)
+	var got []*Metric
+	sink := SinkFunc(func(ctx context.Context, metrics []*Metric) error {
+		got = metrics
+		return nil
+	})
+	c := NewClient(WithSink(sink), WithClock(func() time.Time { return now }))
+
+	cases := []struct {
+		at   time.Time
+		want error
+	}{
+		{now.Add(-59 * time.Minute), nil},
+		{now.Add(-61 * time.Minute), ErrTooOld},
+		{now.Add(9 * time.Minute), nil},
+		{now.Add(11 * time.Minute), ErrTooFuture},
+	}
+	for i, tt := range cases {
+		if err := c.GaugeAt("backfill", float64(i), nil, tt.at); err != tt.want {
+			t.Errorf("Case %d: GaugeAt got %v, want %v", i, err, tt.want)
+		}
+		if err := c.CountAt("jobs", 1, nil, tt.at); err != tt.want {
+			t.Errorf("Case %d: CountAt got %v, want %v", i, err, tt.want)
+		}
+	}
+	c.Gauge("backfill", 9, nil)
+	if err := c.Flush(); err != nil {
+		t.Fatalf("c.Flush(): %v", err)
+	}
+	if len(got) != 2 {
+		t.Fatalf("got %d metrics, want 2", len(got))
+	}
+	ts := float64(now.Unix())
+	want := fmt.Sprint([][2]float64{{ts - 59*60, 0}, {ts + 9*60, 2}, {ts, 9}})
+	if fmt.Sprint(got[0].Value) != want {
+		t.Errorf("got points %v, want %s", got[0].Value, want)
+	}
+}
+
+func TestCountAtSent(t *testing.T) {
+	now := time.Date(2022, 1, 1, 12, 0, 0, 0, time.UTC)
+	sink := SinkFunc(func(ctx context.Context, metrics []*Metric) error {
+		return nil
+	})
+	c := NewClient(WithSink(sink), WithClock(func() time.Time { return now }), WithBuckets(10*time.Second))
+
+	if err := c.CountAt("jobs", 1, nil, now.Add(-25*time.Second)); err != nil {
+		t.Fatalf("c.CountAt(): %v", err)
+	}
+	c.Count("live", 1, nil)
+	if err := c.Flush(); err != nil {
+		t.Fatalf("c.Flush(): %v", err)
+	}
+
+	cases := []struct {
+		name string
+		tags []string
+		at   time.Time
+		want error
+	}{
+		// same bucket as the one sent
+		{"jobs", nil, now.Add(-21 * time.Second), ErrAlreadySent},
+		// other buckets or tags were not sent
+		{"jobs", nil, now.Add(-15 * time.Second), nil},
+		{"jobs", []string{"queue:slow"}, now.Add(-21 * time.Second), nil},
+		// the current bucket is still open, so was not sent
+		{"live", nil, now, nil},
+	}
+	for i, tt := range cases {
+		if err := c.CountAt(tt.name, 1, tt.tags, tt.at); err != tt.want {
+			t.Errorf("Case %d: CountAt got %v, want %v", i, err, tt.want)
+		}
+	}
+
+	// forgotten once too old to send anyway
+	now = now.Add(maxPast + time.Minute)
+	c.Flush()
+	if len(c.sent) != 0 {
+		t.Errorf("got %d sent timestamps remembered, want 0", len(c.sent))
+	}
+}
+
+func TestCountType(t *testing.T) {
+	for _, mtype := range []string{TypeRate, TypeCount} {
+		c := NewClient(WithCountType(mtype))
+		c.Count("count", 30, nil)
+		c.Rate("rate", 30, nil)
+		c.Histogram("histo", 1, nil)
+		snap := c.Snapshot()
+		snap.finalize(snap.lastFlush + 10)
+
+		wantCount := 3.0
+		if mtype == TypeCount {
+			wantCount = 30
+		}
+		want := map[string]struct {
+			mtype string
+			value float64
+		}{
+			"count":       {mtype, wantCount},
+			"rate":        {TypeRate, 3},
+			"histo.count": {mtype, map[string]float64{TypeRate: 0.1, TypeCount: 1}[mtype]},
+		}
+		for _, m := range snap.Series {
+			w, ok := want[m.Name]
+			if !ok {
+				continue
+			}
+			if m.Type != w.mtype || m.Value[0][1] != w.value || m.Interval != 10 {
+				t.Errorf("%s %s: got type %s value %v interval %d, want %s %v 10",
+					mtype, m.Name, m.Type, m.Value[0][1], m.Interval, w.mtype, w.value)
+			}
+		}
+	}
+}
+
+func TestSet(t *testing.T) {
+	for _, opts := range [][]Option{nil, {WithApproximateSets(14)}} {
+		c := NewClient(opts...)
+		for i := 0; i < 100; i++ {
+			c.Set("users", fmt.Sprintf("user%d", i%40), []string{"page:home"})
+		}
+		c.Set("users", "user1", []string{"page:cart"})
+		snap := c.Snapshot()
+		snap.finalize(c.now())
+
+		want := map[string]float64{"page:home": 40, "page:cart": 1}
+		if len(snap.Series) != len(want) {
+			t.Fatalf("got %d series, want %d", len(snap.Series), len(want))
+		}
+		for _, m := range snap.Series {
+			if m.Type != TypeGauge || m.Value[0][1] != want[m.Tags[0]] {
+				t.Errorf("%v: got %s %v, want gauge %v", m.Tags, m.Type, m.Value[0][1], want[m.Tags[0]])
+			}
+		}
+		if c.Snapshot() != nil {
+			t.Errorf("sets not reset after snapshot")
+		}
+	}
+}
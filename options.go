@@ -0,0 +1,125 @@
+package dogdirect
+
+import (
+	"strings"
+	"time"
+)
+
+// Option configures a Client
+type Option func(*Client)
+
+// WithHostname sets the hostname applied to every metric, unless
+// overridden by a "host:" tag
+func WithHostname(hostname string) Option {
+	return func(c *Client) {
+		c.hostname = hostname
+	}
+}
+
+// WithSink sets where metrics are sent on Flush, typically an API
+func WithSink(sink Sink) Option {
+	return func(c *Client) {
+		c.sink = sink
+	}
+}
+
+// WithNamespace prefixes every metric name with namespace.
+// A trailing "." is added if missing.
+func WithNamespace(namespace string) Option {
+	return func(c *Client) {
+		if namespace != "" && !strings.HasSuffix(namespace, ".") {
+			namespace += "."
+		}
+		c.namespace = namespace
+	}
+}
+
+// WithTags adds global tags to every metric.  Tags are deduplicated
+// against the tags of each metric.
+func WithTags(tags ...string) Option {
+	return func(c *Client) {
+		c.tags = unique(append(c.tags, tags...))
+	}
+}
+
+// WithClock replaces time.Now, mostly for testing
+func WithClock(clock func() time.Time) Option {
+	return func(c *Client) {
+		c.now = func() float64 {
+			return float64(clock().Unix())
+		}
+	}
+}
+
+// WithHistogramFactory sets how histograms are created, one per
+// metric context, such as an HDRHistogram or StreamingHistogram to
+// bound memory.  The default is an ExactHistogram.  Timings are
+// separate; see WithTimingFactory.
+func WithHistogramFactory(factory func() Histogram) Option {
+	return func(c *Client) {
+		c.newHistogram = factory
+	}
+}
+
+// WithTimingFactory sets how the histograms of Timing are created.
+// The default is a SketchHistogram.
+func WithTimingFactory(factory func() Histogram) Option {
+	return func(c *Client) {
+		c.newTiming = factory
+	}
+}
+
+// WithFlushInterval sets how often the client expects to be flushed.
+// The default is DefaultFlushInterval.
+func WithFlushInterval(d time.Duration) Option {
+	return func(c *Client) {
+		c.flushInterval = d
+	}
+}
+
+// WithUnit sets the unit of a metric, such as "millisecond".  Units are
+// only sent with the v2 series API.  For histograms, use the name of
+// each aggregate, such as "latency.avg".
+func WithUnit(name string, unit string) Option {
+	return func(c *Client) {
+		if c.units == nil {
+			c.units = make(map[string]string)
+		}
+		c.units[name] = unit
+	}
+}
+
+// WithBuckets aggregates gauges and counts into time buckets of size,
+// like the agent's 10 second buckets, so each flush can send several
+// points per series.  Each value goes in the bucket of when it was
+// recorded, and a bucket is only sent by the first flush after it
+// ends (or by Close).  Histograms are not bucketed.  The size is
+// rounded down to whole seconds.
+func WithBuckets(size time.Duration) Option {
+	return func(c *Client) {
+		c.bucket = float64(size / time.Second)
+	}
+}
+
+// WithCountType sets how counts, including the count of histograms,
+// are sent.  TypeRate, the default, sends the count per second.
+// TypeCount sends the total count with the interval, like dogstatsd.
+// Use Client.Rate for metrics that should always be a rate.
+func WithCountType(mtype string) Option {
+	return func(c *Client) {
+		if mtype == TypeCount || mtype == TypeRate {
+			c.countType = mtype
+		}
+	}
+}
+
+// WithApproximateSets estimates the size of sets with a HyperLogLog,
+// using 2^precision bytes per set no matter how many values it has.
+// The standard error is about 1.04/sqrt(2^precision), so 0.8% for a
+// precision of 14.  Precision is limited to 4 through 16.  The
+// default is exact sets.
+func WithApproximateSets(precision uint8) Option {
+	return func(c *Client) {
+		c.setPrecision = precision
+	}
+}
@@ -0,0 +1,151 @@
+package dogdirect
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrTaskTimeout is the error of a ParallelTask task that took too
+// long.  The task keeps running in the background.
+var ErrTaskTimeout = errors.New("task timed out")
+
+// ParallelTask is a set of FlushClosers that are flushed and closed
+// concurrently, each with its own timeout, so one slow task can't
+// delay the others.  Unlike MultiTask, all errors are returned, as a
+// MultiError.
+type ParallelTask struct {
+	Tasks   []FlushCloser
+	Timeout time.Duration // per task, 0 is no timeout
+}
+
+// NewParallelTask creates a ParallelTask
+func NewParallelTask(timeout time.Duration, tasks ...FlushCloser) *ParallelTask {
+	return &ParallelTask{
+		Tasks:   tasks,
+		Timeout: timeout,
+	}
+}
+
+// TaskError is the error of one task of a ParallelTask
+type TaskError struct {
+	Index int // in Tasks
+	Task  FlushCloser
+	Err   error
+}
+
+func (e *TaskError) Error() string {
+	return fmt.Sprintf("task %d (%T): %v", e.Index, e.Task, e.Err)
+}
+
+// Unwrap returns the underlying error
+func (e *TaskError) Unwrap() error {
+	return e.Err
+}
+
+// MultiError is the errors of every task of a ParallelTask that
+// failed, in task order
+type MultiError []*TaskError
+
+func (e MultiError) Error() string {
+	msgs := make([]string, 0, len(e))
+	for _, te := range e {
+		msgs = append(msgs, te.Error())
+	}
+	return fmt.Sprintf("%d tasks failed: %s", len(e), strings.Join(msgs, "; "))
+}
+
+// Is reports whether any task error is target, for errors.Is
+func (e MultiError) Is(target error) bool {
+	for _, te := range e {
+		if errors.Is(te, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// As finds the first task error that is target, for errors.As
+func (e MultiError) As(target interface{}) bool {
+	for _, te := range e {
+		if errors.As(te, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// Flush flushes every task concurrently
+func (pt *ParallelTask) Flush() error {
+	return pt.run(context.Background(), func(task FlushCloser) error {
+		return task.Flush()
+	})
+}
+
+// Close closes every task concurrently
+func (pt *ParallelTask) Close() error {
+	return pt.run(context.Background(), func(task FlushCloser) error {
+		return task.Close()
+	})
+}
+
+// Shutdown shuts down every task concurrently, using Shutdown if the
+// task has it and Close if not.  Each task is also limited by ctx.
+func (pt *ParallelTask) Shutdown(ctx context.Context) error {
+	return pt.run(ctx, func(task FlushCloser) error {
+		if s, ok := task.(Shutdowner); ok {
+			return s.Shutdown(ctx)
+		}
+		return task.Close()
+	})
+}
+
+// run calls fn on every task concurrently, and waits for them all,
+// or their timeouts
+func (pt *ParallelTask) run(ctx context.Context, fn func(FlushCloser) error) error {
+	errs := make([]error, len(pt.Tasks))
+	var wg sync.WaitGroup
+	for i, task := range pt.Tasks {
+		wg.Add(1)
+		go func(i int, task FlushCloser) {
+			defer wg.Done()
+			errs[i] = pt.runOne(ctx, task, fn)
+		}(i, task)
+	}
+	wg.Wait()
+
+	var merr MultiError
+	for i, err := range errs {
+		if err != nil {
+			merr = append(merr, &TaskError{Index: i, Task: pt.Tasks[i], Err: err})
+		}
+	}
+	if len(merr) == 0 {
+		return nil
+	}
+	return merr
+}
+
+func (pt *ParallelTask) runOne(ctx context.Context, task FlushCloser, fn func(FlushCloser) error) error {
+	if pt.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, pt.Timeout)
+		defer cancel()
+	}
+	errc := make(chan error, 1)
+	go func() {
+		errc <- fn(task)
+	}()
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+		if ctx.Err() == context.DeadlineExceeded {
+			return ErrTaskTimeout
+		}
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,57 @@
+package dogdirect
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// slowFlusher takes a while to flush
+type slowFlusher struct {
+	flushCounter
+	delay time.Duration
+}
+
+func (sf *slowFlusher) Flush() error {
+	time.Sleep(sf.delay)
+	return sf.flushCounter.Flush()
+}
+
+func TestParallelTask(t *testing.T) {
+	ok := &flushCounter{}
+	failing := &flushCounter{fail: 1}
+	slow := &slowFlusher{delay: time.Second}
+	pt := NewParallelTask(50*time.Millisecond, ok, failing, slow)
+
+	start := time.Now()
+	err := pt.Flush()
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("flush took %v, slow task was not timed out", elapsed)
+	}
+	merr, isMulti := err.(MultiError)
+	if !isMulti {
+		t.Fatalf("got %v, want a MultiError", err)
+	}
+	if len(merr) != 2 {
+		t.Fatalf("got %d errors, want 2: %v", len(merr), merr)
+	}
+	if merr[0].Index != 1 || merr[0].Task != failing {
+		t.Errorf("got first error from task %d, want 1", merr[0].Index)
+	}
+	if merr[1].Index != 2 || !errors.Is(merr[1], ErrTaskTimeout) {
+		t.Errorf("got second error %v, want timeout of task 2", merr[1])
+	}
+	if !errors.Is(err, ErrTaskTimeout) {
+		t.Errorf("errors.Is(err, ErrTaskTimeout) is false")
+	}
+
+	ok.mu.Lock()
+	if ok.flushes != 1 {
+		t.Errorf("got %d flushes, want 1", ok.flushes)
+	}
+	ok.mu.Unlock()
+
+	if err := pt.Close(); err != nil {
+		t.Errorf("pt.Close(): %v", err)
+	}
+}
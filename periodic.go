@@ -1,6 +1,9 @@
 package dogdirect
 
 import (
+	"context"
+	"fmt"
+	"sync"
 	"time"
 )
 
@@ -12,14 +15,14 @@ type FlushCloser interface {
 
 // MultiTask is a sequence of FlushClosers
 //  It operates in serial, although the underlyding implimentation
-//  can work in parallel.  See Periodic below
+//  can work in parallel.  See Periodic below, and ParallelTask
 type MultiTask []FlushCloser
 
 // Flush writes out all data and returns first error if any
 func (mt *MultiTask) Flush() error {
 	var errout error
 	for _, task := range *mt {
-		if err := task.Close(); err != nil && errout == nil {
+		if err := task.Flush(); err != nil && errout == nil {
 			errout = err
 		}
 	}
@@ -37,52 +40,401 @@ func (mt *MultiTask) Close() error {
 	return errout
 }
 
+// ContextFlushCloser is a FlushCloser whose submissions can be
+// abandoned with a context, such as Client.  Periodic uses this to
+// enforce shutdown deadlines.
+type ContextFlushCloser interface {
+	FlushCloser
+	FlushContext(ctx context.Context) error
+	CloseContext(ctx context.Context) error
+}
+
+// Shutdowner is a FlushCloser that can shutdown gracefully, such as
+// Periodic
+type Shutdowner interface {
+	Shutdown(ctx context.Context) error
+}
+
+// Shutdown shuts down each task in turn, using Shutdown if the task
+// has it and Close if not, and returns the first error if any
+func (mt *MultiTask) Shutdown(ctx context.Context) error {
+	var errout error
+	for _, task := range *mt {
+		var err error
+		if s, ok := task.(Shutdowner); ok {
+			err = s.Shutdown(ctx)
+		} else {
+			err = task.Close()
+		}
+		if err != nil && errout == nil {
+			errout = err
+		}
+	}
+	return errout
+}
+
+// DefaultShutdownTimeout is how long a Periodic waits for the final
+// flush when its context is done
+const DefaultShutdownTimeout = 10 * time.Second
+
+// abandonGrace is how long Shutdown waits, after its context is done,
+// for abandoned submissions to be kept
+const abandonGrace = time.Second
+
 // Periodic handles flushing data periodically, also satifies the
 // FlushCloser interface so it can be used in MutliTask
 type Periodic struct {
-	client FlushCloser
-	stop   chan struct{}
+	client   FlushCloser
+	duration time.Duration
+	timeout  time.Duration // for the final flush
+	align    bool          // to wall clock multiples of duration
+	splay    time.Duration // fixed random offset of every flush
+
+	lifecycle sync.Mutex
+	quit      chan struct{} // stops the running loop, if any
+	done      chan struct{} // closed when the loop exits
+	inflight  sync.WaitGroup
+	ctx       context.Context // of flushes, cancelled when Shutdown gives up
+	cancel    context.CancelFunc
+
+	onError  func(error)
+	errors   chan<- error
+	logger   Logger
+	mu       sync.Mutex
+	failures int       // consecutive
+	success  time.Time // last
+	lastErr  error
+}
+
+// Logger is where Periodic can log flush errors, such as a *log.Logger
+type Logger interface {
+	Printf(format string, v ...interface{})
+}
+
+// PeriodicOption configures a Periodic
+type PeriodicOption func(*Periodic)
+
+// WithShutdownTimeout sets how long to wait for the final flush when
+// the context given to Start is done.  The default is
+// DefaultShutdownTimeout.
+func WithShutdownTimeout(d time.Duration) PeriodicOption {
+	return func(p *Periodic) {
+		p.timeout = d
+	}
+}
+
+// WithAlignment flushes on wall clock multiples of the duration, so
+// every 15 seconds is at :00, :15, :30 and :45 past the minute, and
+// timestamps line up across hosts.
+func WithAlignment() PeriodicOption {
+	return func(p *Periodic) {
+		p.align = true
+	}
 }
 
-// NewPeriodic task create a new ticket to flush data at regular intervals
-func NewPeriodic(client FlushCloser, duration time.Duration) *Periodic {
+// WithSplay delays every flush by a fixed random amount, up to max
+// (and less than the duration), so many hosts started at the same
+// time don't all flush at the same moment.
+func WithSplay(max time.Duration) PeriodicOption {
+	return func(p *Periodic) {
+		if max > 0 {
+			p.splay = time.Duration(randInt63n(int64(max)))
+		}
+	}
+}
+
+// WithErrorHandler calls fn with every error from a periodic flush.
+// The error is a *FlushError.  fn is called from the flushing
+// goroutine, so should not block.
+func WithErrorHandler(fn func(error)) PeriodicOption {
+	return func(p *Periodic) {
+		p.onError = fn
+	}
+}
+
+// WithErrorChannel sends every error from a periodic flush to ch.
+// The error is a *FlushError.  If ch is not ready the error is
+// discarded, so a buffered channel is best.
+func WithErrorChannel(ch chan<- error) PeriodicOption {
+	return func(p *Periodic) {
+		p.errors = ch
+	}
+}
+
+// WithLogger logs every error from a periodic flush
+func WithLogger(logger Logger) PeriodicOption {
+	return func(p *Periodic) {
+		p.logger = logger
+	}
+}
+
+// FlushError is an error from a periodic flush
+type FlushError struct {
+	Err                 error
+	ConsecutiveFailures int       // including this one
+	LastSuccess         time.Time // zero if never
+}
+
+func (e *FlushError) Error() string {
+	return fmt.Sprintf("periodic flush failed (%d in a row): %v", e.ConsecutiveFailures, e.Err)
+}
+
+// Unwrap returns the underlying error
+func (e *FlushError) Unwrap() error {
+	return e.Err
+}
+
+// NewPeriodic task create a new ticket to flush data at regular
+// intervals.  It is started with a background context; use Start to
+// tie it to another context, and Close or Shutdown to stop it.
+func NewPeriodic(client FlushCloser, duration time.Duration, opts ...PeriodicOption) *Periodic {
 	c := &Periodic{
-		client: client,
+		client:   client,
+		duration: duration,
+		timeout:  DefaultShutdownTimeout,
+	}
+	c.ctx, c.cancel = context.WithCancel(context.Background())
+	for _, opt := range opts {
+		opt(c)
 	}
-	go c.watch(duration)
+	if c.duration > 0 {
+		c.splay %= c.duration
+	}
+	c.Start(context.Background())
 	return c
 }
 
-func (p *Periodic) watch(duration time.Duration) {
-	ticker := time.NewTicker(duration)
+// Start runs the flush loop until ctx is done, and then flushes one
+// last time, waiting up to the shutdown timeout.  If the loop is
+// already running, it is restarted with the new context.
+func (p *Periodic) Start(ctx context.Context) {
+	p.lifecycle.Lock()
+	defer p.lifecycle.Unlock()
+
+	if done := p.stopLoop(); done != nil {
+		<-done
+	}
+	p.mu.Lock()
+	if p.ctx.Err() != nil {
+		// a previous Shutdown gave up
+		p.ctx, p.cancel = context.WithCancel(context.Background())
+	}
+	p.mu.Unlock()
+	p.quit = make(chan struct{})
+	p.done = make(chan struct{})
+	go p.watch(ctx, p.quit, p.done)
+}
+
+// stopLoop tells the running loop, if any, to stop without a final
+// flush, and returns a channel that is closed once it has, which may
+// be after a flush in progress.  Must hold lifecycle lock.
+func (p *Periodic) stopLoop() chan struct{} {
+	if p.quit == nil {
+		return nil
+	}
+	close(p.quit)
+	p.quit = nil
+	return p.done
+}
+
+// flushContext returns the context of flushes
+func (p *Periodic) flushContext() context.Context {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.ctx
+}
+
+func (p *Periodic) watch(ctx context.Context, quit chan struct{}, done chan struct{}) {
+	defer close(done)
+	timer := time.NewTimer(time.Until(p.next(time.Now(), true)))
 
 	for {
 		select {
-		case <-ticker.C:
-			// TODO error is squashed
+		case <-timer.C:
 			if err := p.Flush(); err != nil {
-				// TODO: need call out
+				p.report(err)
 			}
-		case <-p.stop:
-			ticker.Stop()
+			timer.Reset(time.Until(p.next(time.Now(), false)))
+		case <-quit:
+			timer.Stop()
+			return
+		case <-ctx.Done():
+			timer.Stop()
+			p.finalFlush()
 			return
 		}
 	}
 }
 
+// next returns when to flush after now.  Unaligned, the splay only
+// delays the first flush.
+func (p *Periodic) next(now time.Time, first bool) time.Time {
+	if !p.align || p.duration <= 0 {
+		if first {
+			return now.Add(p.duration + p.splay)
+		}
+		return now.Add(p.duration)
+	}
+	t := now.Truncate(p.duration).Add(p.splay)
+	for !t.After(now) {
+		t = t.Add(p.duration)
+	}
+	return t
+}
+
+// finalFlush flushes when the context is done, but only waits up to
+// the shutdown timeout
+func (p *Periodic) finalFlush() {
+	ctx, cancel := context.WithTimeout(p.flushContext(), p.timeout)
+	defer cancel()
+	errc := make(chan error, 1)
+	go func() {
+		errc <- p.flush(ctx, true)
+	}()
+	timer := time.NewTimer(p.timeout)
+	defer timer.Stop()
+	select {
+	case err := <-errc:
+		if err != nil {
+			p.report(err)
+		}
+	case <-timer.C:
+		p.report(fmt.Errorf("final flush timed out after %v", p.timeout))
+	}
+}
+
+// report sends a flush error wherever it was asked to go
+func (p *Periodic) report(err error) {
+	p.mu.Lock()
+	ferr := &FlushError{
+		Err:                 err,
+		ConsecutiveFailures: p.failures,
+		LastSuccess:         p.success,
+	}
+	p.mu.Unlock()
+
+	if p.onError != nil {
+		p.onError(ferr)
+	}
+	if p.errors != nil {
+		select {
+		case p.errors <- ferr:
+		default:
+		}
+	}
+	if p.logger != nil {
+		p.logger.Printf("%v", ferr)
+	}
+}
+
 // Flush causes data to be written out
 func (p *Periodic) Flush() error {
-	return p.client.Flush()
+	return p.flush(p.flushContext(), false)
 }
 
-// Close stops the ticket and closes the client
-func (p *Periodic) Close() error {
+// flush flushes the client, abandoning submissions when ctx is done
+// if the client supports it.  The final flush uses CloseContext, so a
+// Client sends everything, including buckets that have not ended.
+func (p *Periodic) flush(ctx context.Context, final bool) error {
+	p.inflight.Add(1)
+	defer p.inflight.Done()
+	var err error
+	if cc, ok := p.client.(ContextFlushCloser); ok && final {
+		err = cc.CloseContext(ctx)
+	} else if ok {
+		err = cc.FlushContext(ctx)
+	} else {
+		err = p.client.Flush()
+	}
+	p.mu.Lock()
+	if err != nil {
+		p.failures++
+		p.lastErr = err
+	} else {
+		p.failures = 0
+		p.success = time.Now()
+	}
+	p.mu.Unlock()
+	return err
+}
+
+// ConsecutiveFailures returns how many flushes in a row have failed,
+// zero if the last one succeeded
+func (p *Periodic) ConsecutiveFailures() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.failures
+}
+
+// LastSuccess returns when the last successful flush was, zero if
+// never
+func (p *Periodic) LastSuccess() time.Time {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.success
+}
+
+// LastError returns the error of the last failed flush, if any
+func (p *Periodic) LastError() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.lastErr
+}
+
+// Shutdown stops the ticker, closes the client, which flushes any
+// remaining data, and waits for any flushes in progress.  If ctx is
+// done first, it returns the context error, and submissions in
+// progress are abandoned if the client is a ContextFlushCloser (a
+// Client keeps what was abandoned in its spool or retry buffer), or
+// else continue in the background.
+func (p *Periodic) Shutdown(ctx context.Context) error {
 	if p == nil {
 		return nil
 	}
+	p.lifecycle.Lock()
+	done := p.stopLoop()
+	p.lifecycle.Unlock()
+
+	fctx := p.flushContext()
+	errc := make(chan error, 1)
+	p.inflight.Add(1)
+	go func() {
+		if done != nil {
+			// the loop may be in the middle of a flush
+			<-done
+		}
+		var err error
+		if cc, ok := p.client.(ContextFlushCloser); ok {
+			err = cc.CloseContext(fctx)
+		} else {
+			err = p.client.Close()
+		}
+		p.inflight.Done()
+		p.inflight.Wait()
+		errc <- err
+	}()
 	select {
-	case p.stop <- struct{}{}:
-	default:
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+		p.mu.Lock()
+		p.cancel()
+		p.mu.Unlock()
+		if _, ok := p.client.(ContextFlushCloser); ok {
+			// abandoned submissions return promptly, and are kept
+			// in the spool if there is one
+			timer := time.NewTimer(abandonGrace)
+			defer timer.Stop()
+			select {
+			case <-errc:
+			case <-timer.C:
+			}
+		}
+		return ctx.Err()
 	}
-	return p.client.Close()
+}
+
+// Close stops the ticket and closes the client
+func (p *Periodic) Close() error {
+	return p.Shutdown(context.Background())
 }
@@ -0,0 +1,284 @@
+package dogdirect
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// flushCounter is a FlushCloser that fails the first few flushes
+type flushCounter struct {
+	mu      sync.Mutex
+	fail    int
+	flushes int
+	closes  int
+}
+
+func (fc *flushCounter) Flush() error {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	fc.flushes++
+	if fc.flushes <= fc.fail {
+		return errors.New("flush failed")
+	}
+	return nil
+}
+
+func (fc *flushCounter) Close() error {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	fc.closes++
+	return nil
+}
+
+func TestPeriodicErrors(t *testing.T) {
+	fc := &flushCounter{fail: 2}
+	errs := make(chan error, 10)
+	var handled int
+	var mu sync.Mutex
+	p := NewPeriodic(fc, 5*time.Millisecond, WithErrorChannel(errs), WithErrorHandler(func(err error) {
+		mu.Lock()
+		handled++
+		mu.Unlock()
+	}))
+	defer p.Close()
+
+	for i := 1; i <= 2; i++ {
+		select {
+		case err := <-errs:
+			fe, ok := err.(*FlushError)
+			if !ok {
+				t.Fatalf("got %T, want *FlushError", err)
+			}
+			if fe.ConsecutiveFailures != i {
+				t.Errorf("got %d consecutive failures, want %d", fe.ConsecutiveFailures, i)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("timeout waiting for error %d", i)
+		}
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for p.LastSuccess().IsZero() && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if p.LastSuccess().IsZero() {
+		t.Fatalf("no successful flush")
+	}
+	if got := p.ConsecutiveFailures(); got != 0 {
+		t.Errorf("got %d consecutive failures after success, want 0", got)
+	}
+	if p.LastError() == nil {
+		t.Errorf("expected last error")
+	}
+	mu.Lock()
+	if handled != 2 {
+		t.Errorf("error handler called %d times, want 2", handled)
+	}
+	mu.Unlock()
+}
+
+func TestPeriodicLifecycle(t *testing.T) {
+	fc := &flushCounter{}
+	p := NewPeriodic(fc, time.Hour)
+
+	// cancelling the context stops the ticker, and flushes once more
+	ctx, cancel := context.WithCancel(context.Background())
+	p.Start(ctx)
+	cancel()
+	deadline := time.Now().Add(time.Second)
+	for p.LastSuccess().IsZero() && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	fc.mu.Lock()
+	if fc.flushes != 1 {
+		t.Errorf("got %d flushes after cancel, want 1", fc.flushes)
+	}
+	fc.mu.Unlock()
+
+	if err := p.Shutdown(context.Background()); err != nil {
+		t.Fatalf("p.Shutdown(): %v", err)
+	}
+	if fc.closes != 1 {
+		t.Errorf("got %d closes after shutdown, want 1", fc.closes)
+	}
+}
+
+func TestPeriodicFinalFlushBuckets(t *testing.T) {
+	sent := make(chan []*Metric, 1)
+	sink := SinkFunc(func(ctx context.Context, metrics []*Metric) error {
+		sent <- metrics
+		return nil
+	})
+	c := NewClient(WithSink(sink), WithBuckets(10*time.Second))
+	p := NewPeriodic(c, time.Hour)
+	defer p.Close()
+
+	// the final flush sends the bucket that has not ended
+	ctx, cancel := context.WithCancel(context.Background())
+	p.Start(ctx)
+	c.Count("requests", 5, nil)
+	cancel()
+	select {
+	case metrics := <-sent:
+		if len(metrics) != 1 || metrics[0].Name != "requests" {
+			t.Errorf("got %v, want requests", metrics)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("nothing sent by the final flush")
+	}
+	if len(c.Series) != 0 {
+		t.Errorf("got %d series left pending, want 0", len(c.Series))
+	}
+}
+
+// slowCloser takes a while to close, like a slow HTTP submission
+type slowCloser struct {
+	flushCounter
+	delay time.Duration
+}
+
+func (sc *slowCloser) Close() error {
+	time.Sleep(sc.delay)
+	return sc.flushCounter.Close()
+}
+
+func TestPeriodicShutdownDeadline(t *testing.T) {
+	p := NewPeriodic(&slowCloser{delay: time.Second}, time.Hour)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := p.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Errorf("got %v, want %v", err, context.DeadlineExceeded)
+	}
+}
+
+// blockingFlusher is a ContextFlushCloser whose flushes take a while,
+// like a slow HTTP submission, unless cancelled
+type blockingFlusher struct {
+	delay     time.Duration
+	started   chan struct{} // receives when a flush starts
+	cancelled chan struct{} // receives when a flush is cancelled
+	plain     bool          // ignore the context
+}
+
+func (sf *blockingFlusher) FlushContext(ctx context.Context) error {
+	select {
+	case sf.started <- struct{}{}:
+	default:
+	}
+	if sf.plain {
+		ctx = context.Background()
+	}
+	select {
+	case <-time.After(sf.delay):
+		return nil
+	case <-ctx.Done():
+		sf.cancelled <- struct{}{}
+		return ctx.Err()
+	}
+}
+
+func (sf *blockingFlusher) CloseContext(ctx context.Context) error {
+	return sf.FlushContext(ctx)
+}
+
+func (sf *blockingFlusher) Flush() error { return sf.FlushContext(context.Background()) }
+func (sf *blockingFlusher) Close() error { return sf.CloseContext(context.Background()) }
+
+// plainFlusher hides the context methods of blockingFlusher
+type plainFlusher struct {
+	FlushCloser
+}
+
+func TestPeriodicShutdownDuringFlush(t *testing.T) {
+	cases := []struct {
+		name   string
+		client func(*blockingFlusher) FlushCloser
+	}{
+		{"context", func(sf *blockingFlusher) FlushCloser { return sf }},
+		{"plain", func(sf *blockingFlusher) FlushCloser { sf.plain = true; return plainFlusher{sf} }},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			sf := &blockingFlusher{
+				delay:     2 * time.Second,
+				started:   make(chan struct{}),
+				cancelled: make(chan struct{}, 10),
+			}
+			p := NewPeriodic(tc.client(sf), time.Millisecond)
+			select {
+			case <-sf.started:
+			case <-time.After(time.Second):
+				t.Fatalf("flush never started")
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+			defer cancel()
+			start := time.Now()
+			if err := p.Shutdown(ctx); err != context.DeadlineExceeded {
+				t.Errorf("got %v, want %v", err, context.DeadlineExceeded)
+			}
+			if elapsed := time.Since(start); elapsed > time.Second {
+				t.Errorf("shutdown took %v, want about 100ms", elapsed)
+			}
+			if sf.plain {
+				return
+			}
+			select {
+			case <-sf.cancelled:
+			case <-time.After(time.Second):
+				t.Errorf("flush in progress was not cancelled")
+			}
+		})
+	}
+}
+
+func TestMultiTask(t *testing.T) {
+	a, b := &flushCounter{}, &flushCounter{fail: 1}
+	mt := MultiTask{a, b}
+	if err := mt.Flush(); err == nil {
+		t.Errorf("expected flush error")
+	}
+	if a.flushes != 1 || b.flushes != 1 || a.closes != 0 || b.closes != 0 {
+		t.Errorf("Flush: got flushes %d %d, closes %d %d", a.flushes, b.flushes, a.closes, b.closes)
+	}
+	if err := mt.Shutdown(context.Background()); err != nil {
+		t.Errorf("mt.Shutdown(): %v", err)
+	}
+	if a.closes != 1 || b.closes != 1 {
+		t.Errorf("Shutdown: got closes %d %d", a.closes, b.closes)
+	}
+}
+
+func TestPeriodicNext(t *testing.T) {
+	now := time.Date(2022, 1, 1, 10, 20, 7, 0, time.UTC)
+	cases := []struct {
+		align bool
+		splay time.Duration
+		first bool
+		want  time.Time
+	}{
+		{false, 0, true, now.Add(15 * time.Second)},
+		{false, 2 * time.Second, true, now.Add(17 * time.Second)},
+		{false, 2 * time.Second, false, now.Add(15 * time.Second)},
+		{true, 0, true, time.Date(2022, 1, 1, 10, 20, 15, 0, time.UTC)},
+		{true, 0, false, time.Date(2022, 1, 1, 10, 20, 15, 0, time.UTC)},
+		{true, 3 * time.Second, false, time.Date(2022, 1, 1, 10, 20, 18, 0, time.UTC)},
+		{true, 9 * time.Second, false, time.Date(2022, 1, 1, 10, 20, 9, 0, time.UTC)},
+	}
+	for i, tt := range cases {
+		p := &Periodic{duration: 15 * time.Second, align: tt.align, splay: tt.splay}
+		if got := p.next(now, tt.first); !got.Equal(tt.want) {
+			t.Errorf("Case %d: got %v, want %v", i, got, tt.want)
+		}
+	}
+
+	// exactly on a boundary is the next one
+	p := &Periodic{duration: 15 * time.Second, align: true}
+	at := time.Date(2022, 1, 1, 10, 20, 15, 0, time.UTC)
+	if got := p.next(at, false); !got.Equal(at.Add(15 * time.Second)) {
+		t.Errorf("got %v, want %v", got, at.Add(15*time.Second))
+	}
+}
@@ -0,0 +1,145 @@
+package dogdirect
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// random is seeded per process.  Before Go 1.20 the global source
+// always starts the same, so every process would jitter alike.
+var random = struct {
+	sync.Mutex
+	*rand.Rand
+}{Rand: rand.New(rand.NewSource(time.Now().UnixNano()))}
+
+// randInt63n is rand.Int63n from random
+func randInt63n(n int64) int64 {
+	random.Lock()
+	defer random.Unlock()
+	return random.Int63n(n)
+}
+
+// RetryPolicy controls how failed requests are retried.  Only
+// temporary failures are retried; see IsTemporary.
+type RetryPolicy struct {
+	MaxAttempts int           // including the first, so 1 or less is no retries
+	BaseDelay   time.Duration // before the first retry, doubling after each
+	MaxDelay    time.Duration // longest wait, including Retry-After
+}
+
+// DefaultRetryPolicy makes 3 attempts, which fits comfortably in a
+// 15 second flush interval
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 3,
+	BaseDelay:   500 * time.Millisecond,
+	MaxDelay:    5 * time.Second,
+}
+
+// WithRetry retries failed requests.  The default is no retries.
+func WithRetry(policy RetryPolicy) APIOption {
+	return func(a *API) {
+		a.retry = policy
+	}
+}
+
+// delay is how long to wait before the next attempt, after attempt
+// number n failed with err.  If the server wants a wait longer than
+// MaxDelay, it returns false.
+func (p RetryPolicy) delay(n int, err error) (time.Duration, bool) {
+	var se *StatusError
+	if errors.As(err, &se) && se.RetryAfter > 0 {
+		if p.MaxDelay > 0 && se.RetryAfter > p.MaxDelay {
+			return 0, false
+		}
+		return se.RetryAfter, true
+	}
+
+	d := p.BaseDelay << uint(n-1)
+	if d <= 0 || (p.MaxDelay > 0 && d > p.MaxDelay) {
+		d = p.MaxDelay
+	}
+	// jitter in [d/2, d) so clients don't retry in lockstep
+	if half := int64(d / 2); half > 0 {
+		d = time.Duration(half + randInt63n(half))
+	}
+	return d, true
+}
+
+// StatusError is an unsuccessful HTTP response from the API
+type StatusError struct {
+	StatusCode int
+	Body       string
+	RetryAfter time.Duration // requested by the server, if any
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("http status %v: %s", e.StatusCode, e.Body)
+}
+
+// Temporary is true for rate limiting, timeouts and server errors,
+// which might succeed if retried
+func (e *StatusError) Temporary() bool {
+	switch {
+	case e.StatusCode == http.StatusTooManyRequests,
+		e.StatusCode == http.StatusRequestTimeout,
+		e.StatusCode >= 500:
+		return true
+	}
+	return false
+}
+
+// IsTemporary reports whether err is a failure that might succeed if
+// retried later, such as a server error or a network problem.  Bad
+// requests, bad keys and cancelled contexts are permanent.
+func IsTemporary(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, context.Canceled) {
+		return false
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var be *BatchError
+	if errors.As(err, &be) {
+		for _, f := range be.Failed {
+			if IsTemporary(f.Err) {
+				return true
+			}
+		}
+		return false
+	}
+	var se *StatusError
+	if errors.As(err, &se) {
+		return se.Temporary()
+	}
+	var ne net.Error
+	return errors.As(err, &ne)
+}
+
+// retryAfter returns how long the server asked to wait, from either
+// Retry-After or Datadog's X-RateLimit-Reset, in seconds
+func retryAfter(h http.Header, now time.Time) time.Duration {
+	if v := h.Get("Retry-After"); v != "" {
+		if secs, err := strconv.Atoi(v); err == nil && secs > 0 {
+			return time.Duration(secs) * time.Second
+		}
+		if t, err := http.ParseTime(v); err == nil && t.After(now) {
+			return t.Sub(now)
+		}
+	}
+	if v := h.Get("X-RateLimit-Reset"); v != "" {
+		if secs, err := strconv.Atoi(v); err == nil && secs > 0 {
+			return time.Duration(secs) * time.Second
+		}
+	}
+	return 0
+}
@@ -0,0 +1,90 @@
+package dogdirect
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRetry(t *testing.T) {
+	cases := []struct {
+		statuses []int
+		attempts int32
+		wantErr  bool
+	}{
+		{[]int{503, 202}, 2, false},
+		{[]int{429, 500, 202}, 3, false},
+		{[]int{503, 503, 503, 202}, 3, true},
+		{[]int{400, 202}, 1, true},
+		{[]int{403, 202}, 1, true},
+	}
+	policy := RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: 10 * time.Millisecond}
+	for i, tt := range cases {
+		var attempts int32
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			n := atomic.AddInt32(&attempts, 1)
+			w.WriteHeader(tt.statuses[n-1])
+		}))
+		api := NewAPI("foo", "bar", 0, WithEndpoint(ts.URL), WithRetry(policy))
+		err := api.AddPoints(nil)
+		ts.Close()
+		if (err != nil) != tt.wantErr {
+			t.Errorf("Case %d: got error %v", i, err)
+		}
+		if attempts != tt.attempts {
+			t.Errorf("Case %d: got %d attempts, want %d", i, attempts, tt.attempts)
+		}
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	now := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	cases := []struct {
+		header string
+		value  string
+		want   time.Duration
+	}{
+		{"Retry-After", "7", 7 * time.Second},
+		{"Retry-After", now.Add(time.Minute).Format(http.TimeFormat), time.Minute},
+		{"Retry-After", "soon", 0},
+		{"X-RateLimit-Reset", "3", 3 * time.Second},
+	}
+	for i, tt := range cases {
+		h := http.Header{}
+		h.Set(tt.header, tt.value)
+		if got := retryAfter(h, now); got != tt.want {
+			t.Errorf("Case %d: got %v, want %v", i, got, tt.want)
+		}
+	}
+
+	policy := RetryPolicy{MaxAttempts: 3, MaxDelay: 5 * time.Second}
+	if _, ok := policy.delay(1, &StatusError{StatusCode: 429, RetryAfter: time.Minute}); ok {
+		t.Errorf("expected no retry when Retry-After exceeds MaxDelay")
+	}
+	if d, _ := policy.delay(1, &StatusError{StatusCode: 429, RetryAfter: time.Second}); d != time.Second {
+		t.Errorf("got delay %v, want 1s", d)
+	}
+}
+
+func TestIsTemporary(t *testing.T) {
+	cases := []struct {
+		err  error
+		want bool
+	}{
+		{nil, false},
+		{errors.New("json: unsupported value"), false},
+		{&StatusError{StatusCode: 400}, false},
+		{&StatusError{StatusCode: 403}, false},
+		{&StatusError{StatusCode: 429}, true},
+		{&StatusError{StatusCode: 503}, true},
+		{&BatchError{Batches: 2, Failed: []BatchFailure{{Err: &StatusError{StatusCode: 503}}}}, true},
+	}
+	for i, tt := range cases {
+		if got := IsTemporary(tt.err); got != tt.want {
+			t.Errorf("Case %d: IsTemporary(%v) = %v, want %v", i, tt.err, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package dogdirect
+
+import (
+	"sync"
+	"time"
+)
+
+// maxPointAge is how old a point can be and still be accepted
+const maxPointAge = time.Hour
+
+// WithRetryBuffer keeps metrics that failed with a temporary error in
+// memory, up to about maxBytes, and resends them with the next flush.
+// When the buffer is full the oldest metrics are dropped, or written
+// to the spool if there is one.  See also Client.DroppedPoints.
+func WithRetryBuffer(maxBytes int) Option {
+	return func(c *Client) {
+		c.retries = &retryBuffer{maxBytes: maxBytes}
+	}
+}
+
+// retryBuffer is a queue of metrics that failed to submit, oldest
+// first, limited to an approximate size in bytes
+type retryBuffer struct {
+	maxBytes int
+	size     int
+	metrics  []*Metric
+	dropped  int64 // points
+
+	sync.Mutex
+}
+
+// metricSize estimates the JSON size of a metric, without the cost
+// of encoding it
+func metricSize(m *Metric) int {
+	n := 64 + len(m.Name) + len(m.Hostname) + 32*len(m.Value)
+	for _, tag := range m.Tags {
+		n += len(tag) + 3
+	}
+	return n
+}
+
+// push adds metrics to the end of the queue, and returns the oldest
+// metrics that no longer fit
+func (rb *retryBuffer) push(metrics []*Metric) []*Metric {
+	rb.Lock()
+	defer rb.Unlock()
+
+	for _, m := range metrics {
+		rb.size += metricSize(m)
+	}
+	rb.metrics = append(rb.metrics, metrics...)
+
+	i := 0
+	for i < len(rb.metrics) && rb.size > rb.maxBytes {
+		rb.size -= metricSize(rb.metrics[i])
+		i++
+	}
+	if i == 0 {
+		return nil
+	}
+	evicted := append([]*Metric(nil), rb.metrics[:i]...)
+	rb.metrics = append([]*Metric(nil), rb.metrics[i:]...)
+	return evicted
+}
+
+// take empties the queue, dropping metrics too old to be accepted
+func (rb *retryBuffer) take(nowUnix float64) []*Metric {
+	rb.Lock()
+	defer rb.Unlock()
+
+	cutoff := nowUnix - maxPointAge.Seconds()
+	var out []*Metric
+	for _, m := range rb.metrics {
+		if len(m.Value) != 0 && m.Value[0][0] < cutoff {
+			rb.dropped += int64(len(m.Value))
+			continue
+		}
+		out = append(out, m)
+	}
+	rb.metrics = nil
+	rb.size = 0
+	return out
+}
+
+// drop counts points that were thrown away
+func (rb *retryBuffer) drop(metrics []*Metric) {
+	rb.Lock()
+	for _, m := range metrics {
+		rb.dropped += int64(len(m.Value))
+	}
+	rb.Unlock()
+}
+
+// DroppedPoints returns how many points the retry buffer has thrown
+// away, because it was full or they were too old to submit
+func (c *Client) DroppedPoints() int64 {
+	if c.retries == nil {
+		return 0
+	}
+	c.retries.Lock()
+	defer c.retries.Unlock()
+	return c.retries.dropped
+}
+
+// retain keeps metrics that failed with a temporary error, in the
+// retry buffer or the spool
+func (c *Client) retain(metrics []*Metric) error {
+	return c.keep(metrics, false)
+}
+
+// abandon keeps metrics whose submission was abandoned, most likely
+// because the process is shutting down, so they go straight to the
+// spool if there is one rather than to memory
+func (c *Client) abandon(metrics []*Metric) error {
+	return c.keep(metrics, true)
+}
+
+func (c *Client) keep(metrics []*Metric, disk bool) error {
+	if len(metrics) == 0 {
+		return nil
+	}
+	if c.retries != nil && !(disk && c.spool != nil) {
+		metrics = c.retries.push(metrics)
+		if len(metrics) == 0 {
+			return nil
+		}
+		if c.spool == nil {
+			c.retries.drop(metrics)
+			return nil
+		}
+	}
+	if c.spool != nil {
+		return c.spool.Write(metrics)
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+package dogdirect
+
+import (
+	"context"
+	"testing"
+)
+
+func TestRetryBuffer(t *testing.T) {
+	var sent []*Metric
+	var fail error
+	sink := SinkFunc(func(ctx context.Context, metrics []*Metric) error {
+		if fail != nil {
+			return fail
+		}
+		sent = append(sent, metrics...)
+		return nil
+	})
+	// room for about two metrics
+	m := NewMetric("aaaa", TypeGauge, nil)
+	m.Value = [][2]float64{{0, 1}}
+	size := metricSize(m)
+	c := NewClient(WithHostname("hostname"), WithSink(sink), WithRetryBuffer(2*size+size/2))
+
+	fail = &StatusError{StatusCode: 503}
+	for _, name := range []string{"aaaa", "bbbb", "cccc"} {
+		c.Gauge(name, 1, nil)
+		if err := c.Flush(); err == nil {
+			t.Fatalf("expected flush error")
+		}
+	}
+	if got := c.DroppedPoints(); got != 1 {
+		t.Errorf("got %d dropped points, want 1", got)
+	}
+
+	// nothing new, but the buffer is resent
+	fail = nil
+	if err := c.Flush(); err != nil {
+		t.Fatalf("c.Flush(): %v", err)
+	}
+	if len(sent) != 2 || sent[0].Name != "bbbb" || sent[1].Name != "cccc" {
+		t.Errorf("got sent %v", sent)
+	}
+
+	// permanent errors are not retained
+	fail = &StatusError{StatusCode: 400}
+	c.Gauge("dddd", 1, nil)
+	c.Flush()
+	fail = nil
+	sent = nil
+	if err := c.Flush(); err != nil || len(sent) != 0 {
+		t.Errorf("got sent %v, err %v", sent, err)
+	}
+}
@@ -0,0 +1,111 @@
+package dogdirect
+
+import (
+	"context"
+)
+
+/* https://docs.datadoghq.com/api/latest/metrics/#submit-metrics
+ *
+ * The v2 series API is the same idea as v1, but with integer metric
+ * types, points as objects, the host as a "resource", and optional
+ * units and origin metadata.
+ */
+
+// SeriesType is the metric type of the v2 series API
+type SeriesType int
+
+// v2 metric types
+const (
+	SeriesUnspecified SeriesType = 0
+	SeriesCount       SeriesType = 1
+	SeriesRate        SeriesType = 2
+	SeriesGauge       SeriesType = 3
+)
+
+var seriesTypes = map[string]SeriesType{
+	TypeCount: SeriesCount,
+	TypeRate:  SeriesRate,
+	TypeGauge: SeriesGauge,
+}
+
+// SeriesPoint is a single point of a v2 series
+type SeriesPoint struct {
+	Timestamp int64   `json:"timestamp"`
+	Value     float64 `json:"value"`
+}
+
+// SeriesResource is something a v2 series is associated with,
+// typically a host
+type SeriesResource struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+// SeriesOrigin describes where a v2 series came from
+type SeriesOrigin struct {
+	Product       int `json:"origin_product,omitempty"`
+	SubProduct    int `json:"origin_sub_product,omitempty"`
+	ProductDetail int `json:"origin_product_detail,omitempty"`
+}
+
+// SeriesMetadata is extra information about a v2 series
+type SeriesMetadata struct {
+	Origin *SeriesOrigin `json:"origin,omitempty"`
+}
+
+// Series is the JSON that Datadog wants when posting to the v2
+// series API
+type Series struct {
+	Metric    string           `json:"metric"`
+	Type      SeriesType       `json:"type"`
+	Points    []SeriesPoint    `json:"points"`
+	Resources []SeriesResource `json:"resources,omitempty"`
+	Tags      []string         `json:"tags,omitempty"`
+	Unit      string           `json:"unit,omitempty"`
+	Interval  int64            `json:"interval,omitempty"`
+	Metadata  *SeriesMetadata  `json:"metadata,omitempty"`
+}
+
+// NewSeries converts a v1 metric to a v2 series
+func NewSeries(m *Metric) Series {
+	s := Series{
+		Metric:   m.Name,
+		Type:     seriesTypes[m.Type],
+		Points:   make([]SeriesPoint, 0, len(m.Value)),
+		Tags:     m.Tags,
+		Unit:     m.Unit,
+		Interval: int64(m.Interval),
+	}
+	for _, p := range m.Value {
+		s.Points = append(s.Points, SeriesPoint{
+			Timestamp: int64(p[0]),
+			Value:     p[1],
+		})
+	}
+	if m.Hostname != "" {
+		s.Resources = []SeriesResource{{Name: m.Hostname, Type: "host"}}
+	}
+	return s
+}
+
+func (a API) toSeries(metrics []*Metric) []Series {
+	series := make([]Series, 0, len(metrics))
+	for _, m := range metrics {
+		s := NewSeries(m)
+		if a.origin != nil {
+			s.Metadata = &SeriesMetadata{Origin: a.origin}
+		}
+		series = append(series, s)
+	}
+	return series
+}
+
+// AddSeries posts series to the v2 series endpoint
+func (a API) AddSeries(ctx context.Context, series []Series) error {
+	post := map[string][]Series{
+		"series": series,
+	}
+	endpoint := a.endpoint + "/api/v2/series"
+
+	return a.write(ctx, endpoint, post, false)
+}
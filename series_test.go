@@ -0,0 +1,42 @@
+package dogdirect
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestSeriesV2(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer r.Body.Close()
+		if r.URL.Path != "/api/v2/series" {
+			t.Errorf("got path %q", r.URL.Path)
+		}
+		got, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Fatalf("Failed to read request body: %v", err)
+		}
+
+		want := `{"series":[{"metric":"app.latency","type":3,"points":[{"timestamp":1640995200,"value":12.5}],"resources":[{"name":"hostname","type":"host"}],"tags":["role:web"],"unit":"millisecond","metadata":{"origin":{"origin_product":10}}}]}`
+		if string(got) != want {
+			t.Errorf("got %s, want %s", got, want)
+		}
+
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer ts.Close()
+
+	api := NewAPI("foo", "bar", 0, WithEndpoint(ts.URL), WithSeriesV2(), WithOrigin(SeriesOrigin{Product: 10}))
+	clock := func() time.Time {
+		return time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	}
+	c := NewClient(WithHostname("hostname"), WithSink(api), WithClock(clock),
+		WithNamespace("app"), WithUnit("latency", "millisecond"))
+
+	c.Gauge("latency", 12.5, []string{"role:web"})
+	if err := c.Flush(); err != nil {
+		t.Fatalf("c.Flush(): %v", err)
+	}
+}
@@ -0,0 +1,177 @@
+package dogdirect
+
+import (
+	"math"
+	"sort"
+)
+
+/*
+ * https://www.vldb.org/pvldb/vol12/p2195-masson.pdf
+ * https://github.com/DataDog/datadog-agent/tree/main/pkg/quantile
+ *
+ * A DDSketch puts each value in a bin of exponentially increasing
+ * width, so any quantile is accurate to a relative error, no matter
+ * how many values there are.  Sketches from different hosts can be
+ * merged, which is what makes global percentiles of distributions
+ * possible.
+ *
+ * The mapping of values to bins must match the agent's, since the
+ * bins are sent as is.
+ */
+
+const (
+	sketchEps      = 1.0 / 128 // relative accuracy
+	sketchMin      = 1e-9      // smaller values are treated as 0
+	sketchMaxKey   = math.MaxInt16
+	sketchBinLimit = 4096
+)
+
+var (
+	sketchGamma   = 1 + 2*sketchEps
+	sketchLnGamma = math.Log1p(2 * sketchEps)
+	sketchBias    = -int(math.Floor(math.Log(sketchMin)/sketchLnGamma)) + 1
+)
+
+// SketchRelativeAccuracy is the relative error of quantiles from a
+// Sketch
+const SketchRelativeAccuracy = sketchEps
+
+// Sketch is a DDSketch, the same quantile sketch that the agent uses
+// for distributions.  Quantiles are accurate to within
+// SketchRelativeAccuracy, and memory is bounded.
+type Sketch struct {
+	bins  map[int]uint32 // key to count
+	count uint64
+	min   float64
+	max   float64
+	sum   float64
+}
+
+// NewSketch creates an empty sketch
+func NewSketch() *Sketch {
+	return &Sketch{
+		bins: make(map[int]uint32),
+	}
+}
+
+// sketchKey returns the bin of a value
+func sketchKey(v float64) int {
+	if v < 0 {
+		return -sketchKey(-v)
+	}
+	if v < sketchMin {
+		return 0
+	}
+	k := math.Round(math.Log(v)/sketchLnGamma) + float64(sketchBias)
+	if k > sketchMaxKey {
+		return sketchMaxKey
+	}
+	if k < 1 {
+		return 1
+	}
+	return int(k)
+}
+
+// sketchValue returns the value that represents a bin
+func sketchValue(k int) float64 {
+	if k < 0 {
+		return -sketchValue(-k)
+	}
+	if k == 0 {
+		return 0
+	}
+	return math.Exp(float64(k-sketchBias) * sketchLnGamma)
+}
+
+// Add adds a value
+func (s *Sketch) Add(v float64) {
+	if s.count == 0 || v < s.min {
+		s.min = v
+	}
+	if s.count == 0 || v > s.max {
+		s.max = v
+	}
+	s.count++
+	s.sum += v
+
+	k := sketchKey(v)
+	if _, ok := s.bins[k]; !ok && len(s.bins) >= sketchBinLimit {
+		s.collapse()
+	}
+	s.bins[k]++
+}
+
+// collapse merges the two lowest bins, to make room for another,
+// which only loses accuracy for the lowest quantiles
+func (s *Sketch) collapse() {
+	if len(s.bins) < 2 {
+		return
+	}
+	// the two lowest keys, without sorting
+	lo, next := math.MaxInt32, math.MaxInt32
+	for k := range s.bins {
+		if k < lo {
+			lo, next = k, lo
+		} else if k < next {
+			next = k
+		}
+	}
+	s.bins[next] += s.bins[lo]
+	delete(s.bins, lo)
+}
+
+// keys returns the bin keys, in order
+func (s *Sketch) keys() []int {
+	keys := make([]int, 0, len(s.bins))
+	for k := range s.bins {
+		keys = append(keys, k)
+	}
+	sort.Ints(keys)
+	return keys
+}
+
+// Count returns the number of values
+func (s *Sketch) Count() uint64 {
+	return s.count
+}
+
+// Quantile returns the approximate value at quantile q, between 0
+// and 1
+func (s *Sketch) Quantile(q float64) float64 {
+	if s.count == 0 {
+		return 0
+	}
+	if q <= 0 {
+		return s.min
+	}
+	if q >= 1 {
+		return s.max
+	}
+	rank := q * float64(s.count-1)
+	seen := 0.0
+	for _, k := range s.keys() {
+		seen += float64(s.bins[k])
+		if seen > rank {
+			// the bins are approximate, the extremes are not
+			return math.Min(math.Max(sketchValue(k), s.min), s.max)
+		}
+	}
+	return s.max
+}
+
+// Reset empties the sketch
+func (s *Sketch) Reset() {
+	s.bins = make(map[int]uint32)
+	s.count = 0
+	s.min, s.max, s.sum = 0, 0, 0
+}
+
+// SketchSeries is a distribution to submit: a sketch of all values
+// of a metric from one host over one interval
+type SketchSeries struct {
+	Name      string
+	Host      string
+	Tags      []string
+	Timestamp int64 // unix seconds
+	Sketch    *Sketch
+}
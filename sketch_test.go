@@ -0,0 +1,127 @@
+package dogdirect
+
+import (
+	"context"
+	"encoding/hex"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSketchKey(t *testing.T) {
+	// must match the agent
+	if sketchBias != 1338 {
+		t.Errorf("got bias %d, want 1338", sketchBias)
+	}
+	cases := []struct {
+		v    float64
+		want int
+	}{
+		{0, 0},
+		{1e-10, 0},
+		{1, 1338},
+		{-1, -1338},
+		{sketchGamma, 1339},
+		{math.Inf(1), sketchMaxKey},
+	}
+	for i, tt := range cases {
+		if got := sketchKey(tt.v); got != tt.want {
+			t.Errorf("Case %d: sketchKey(%v) = %d, want %d", i, tt.v, got, tt.want)
+		}
+	}
+}
+
+func TestSketchQuantile(t *testing.T) {
+	s := NewSketch()
+	for i := 1; i <= 10000; i++ {
+		s.Add(float64(i))
+	}
+	if s.Count() != 10000 {
+		t.Errorf("got count %d", s.Count())
+	}
+	for _, q := range []float64{0, 0.5, 0.9, 0.99, 1} {
+		want := 1 + q*9999
+		got := s.Quantile(q)
+		if math.Abs(got-want)/want > 2*SketchRelativeAccuracy {
+			t.Errorf("q=%v: got %v, want %v", q, got, want)
+		}
+	}
+	s.Reset()
+	if s.Count() != 0 || s.Quantile(0.5) != 0 {
+		t.Errorf("not empty after reset")
+	}
+}
+
+func TestSketchBinLimit(t *testing.T) {
+	s := NewSketch()
+	for i := 0; i < 2*sketchBinLimit; i++ {
+		s.Add(math.Pow(sketchGamma, float64(i)))
+	}
+	if len(s.bins) > sketchBinLimit {
+		t.Errorf("got %d bins, limit %d", len(s.bins), sketchBinLimit)
+	}
+	if s.Count() != 2*sketchBinLimit {
+		t.Errorf("got count %d", s.Count())
+	}
+}
+
+func TestEncodeSketches(t *testing.T) {
+	s := NewSketch()
+	s.Add(1)
+	got := hex.EncodeToString(encodeSketches([]*SketchSeries{{
+		Name:      "m",
+		Host:      "h",
+		Tags:      []string{"t"},
+		Timestamp: 1,
+		Sketch:    s,
+	}}))
+	one := "000000000000f03f"
+	dogsketch := "0801" + "1001" + "19" + one + "21" + one + "29" + one + "31" + one + "3a02f414" + "420101"
+	sketch := "0a016d" + "120168" + "220174" + "3a2f" + dogsketch
+	want := "0a3a" + sketch
+	if got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+func TestDistribution(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer r.Body.Close()
+		if r.URL.Path != "/api/beta/sketches" {
+			t.Errorf("got path %q", r.URL.Path)
+		}
+		if got := r.Header.Get("Content-Type"); got != "application/x-protobuf" {
+			t.Errorf("got Content-Type %q", got)
+		}
+		body, _ := io.ReadAll(r.Body)
+		for _, s := range []string{"app.latency", "db-7", "role:db", "env:prod"} {
+			if !strings.Contains(string(body), s) {
+				t.Errorf("payload missing %q", s)
+			}
+		}
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer ts.Close()
+
+	api := NewAPI("foo", "bar", 0, WithEndpoint(ts.URL))
+	c := NewClient(WithSink(api), WithNamespace("app"), WithTags("env:prod"))
+	for i := 0; i < 100; i++ {
+		c.Distribution("latency", float64(i), []string{"host:db-7", "role:db"})
+	}
+	if err := c.Flush(); err != nil {
+		t.Fatalf("c.Flush(): %v", err)
+	}
+
+	// sinks without sketch support get an error
+	c = NewClient(WithSink(SinkFunc(func(ctx context.Context, metrics []*Metric) error {
+		return nil
+	})), WithClock(func() time.Time { return time.Unix(0, 0) }))
+	c.Distribution("latency", 1, nil)
+	if err := c.Flush(); err != errNoSketchSink {
+		t.Errorf("got %v, want %v", err, errNoSketchSink)
+	}
+}
@@ -0,0 +1,124 @@
+package dogdirect
+
+import (
+	"encoding/binary"
+	"math"
+)
+
+/* The agent's sketch payload, from agent_payload.proto.  Only the
+ * fields used here are listed.  It is encoded by hand to avoid a
+ * protobuf dependency.
+
+message SketchPayload {
+  message Sketch {
+    message Dogsketch {
+      int64 ts = 1;
+      int64 cnt = 2;
+      double min = 3;
+      double max = 4;
+      double avg = 5;
+      double sum = 6;
+      repeated sint32 k = 7;
+      repeated uint32 n = 8;
+    }
+    string metric = 1;
+    string host = 2;
+    repeated string tags = 4;
+    repeated Dogsketch dogsketches = 7;
+  }
+  repeated Sketch sketches = 1;
+}
+*/
+
+// protobuf is a request body that is already encoded
+type protobuf []byte
+
+// protobuf wire types
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+)
+
+func appendVarint(b []byte, v uint64) []byte {
+	for v >= 0x80 {
+		b = append(b, byte(v)|0x80)
+		v >>= 7
+	}
+	return append(b, byte(v))
+}
+
+func appendField(b []byte, field int, wire int) []byte {
+	return appendVarint(b, uint64(field<<3|wire))
+}
+
+func appendInt(b []byte, field int, v int64) []byte {
+	if v == 0 {
+		return b
+	}
+	return appendVarint(appendField(b, field, wireVarint), uint64(v))
+}
+
+func appendDouble(b []byte, field int, v float64) []byte {
+	if v == 0 {
+		return b
+	}
+	b = appendField(b, field, wireFixed64)
+	var raw [8]byte
+	binary.LittleEndian.PutUint64(raw[:], math.Float64bits(v))
+	return append(b, raw[:]...)
+}
+
+func appendBytes(b []byte, field int, v []byte) []byte {
+	b = appendField(b, field, wireBytes)
+	b = appendVarint(b, uint64(len(v)))
+	return append(b, v...)
+}
+
+func appendString(b []byte, field int, v string) []byte {
+	if v == "" {
+		return b
+	}
+	return appendBytes(b, field, []byte(v))
+}
+
+// encodeDogsketch encodes a sketch as a Dogsketch message
+func encodeDogsketch(ts int64, s *Sketch) []byte {
+	var b []byte
+	b = appendInt(b, 1, ts)
+	b = appendInt(b, 2, int64(s.count))
+	b = appendDouble(b, 3, s.min)
+	b = appendDouble(b, 4, s.max)
+	b = appendDouble(b, 5, s.sum/float64(s.count))
+	b = appendDouble(b, 6, s.sum)
+
+	// packed repeated fields
+	var keys, counts []byte
+	for _, k := range s.keys() {
+		// sint32 is zigzag encoded
+		keys = appendVarint(keys, uint64(uint32(int32(k)<<1)^uint32(int32(k)>>31)))
+		counts = appendVarint(counts, uint64(s.bins[k]))
+	}
+	b = appendBytes(b, 7, keys)
+	b = appendBytes(b, 8, counts)
+	return b
+}
+
+// encodeSketches encodes a SketchPayload
+func encodeSketches(series []*SketchSeries) protobuf {
+	var b []byte
+	for _, ss := range series {
+		if ss.Sketch == nil || ss.Sketch.count == 0 {
+			continue
+		}
+		var msg []byte
+		msg = appendString(msg, 1, ss.Name)
+		msg = appendString(msg, 2, ss.Host)
+		for _, tag := range ss.Tags {
+			msg = appendString(msg, 4, tag)
+		}
+		msg = appendBytes(msg, 7, encodeDogsketch(ss.Timestamp, ss.Sketch))
+		b = appendBytes(b, 1, msg)
+	}
+	return protobuf(b)
+}
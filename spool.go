@@ -0,0 +1,269 @@
+package dogdirect
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultSpoolMaxAge is the longest metrics are kept in a spool.
+// Datadog rejects points more than an hour old, and this leaves some
+// margin for clock skew and the time to replay.
+const DefaultSpoolMaxAge = 55 * time.Minute
+
+const spoolExt = ".json"
+
+// Spool is a directory of metrics that failed to submit, so they can
+// be sent later, even after a restart.  Each failed submission is
+// one file, and files are replayed oldest first.
+type Spool struct {
+	dir      string
+	maxBytes int64
+	maxAge   time.Duration
+	now      func() time.Time // for testing
+
+	sync.Mutex
+}
+
+// spooled is a metric as stored in the spool, including the fields
+// not sent with the v1 API
+type spooled struct {
+	*Metric
+	Unit string `json:"unit,omitempty"`
+}
+
+// NewSpool creates a spool in dir, creating it if needed.  The spool
+// is limited to maxBytes on disk, and points older than maxAge, by
+// their timestamps, are dropped.  A maxAge of zero, or over DefaultSpoolMaxAge, is
+// DefaultSpoolMaxAge.
+func NewSpool(dir string, maxBytes int64, maxAge time.Duration) (*Spool, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	if maxAge <= 0 || maxAge > DefaultSpoolMaxAge {
+		maxAge = DefaultSpoolMaxAge
+	}
+	return &Spool{
+		dir:      dir,
+		maxBytes: maxBytes,
+		maxAge:   maxAge,
+		now:      time.Now,
+	}, nil
+}
+
+// WithSpool saves metrics that failed with a temporary error to
+// spool, and replays them on the next successful flush
+func WithSpool(spool *Spool) Option {
+	return func(c *Client) {
+		c.spool = spool
+	}
+}
+
+// Write saves metrics to the spool, then drops the oldest files if
+// the spool is too big
+func (s *Spool) Write(metrics []*Metric) error {
+	if len(metrics) == 0 {
+		return nil
+	}
+	s.Lock()
+	defer s.Unlock()
+	metrics = s.fresh(metrics)
+	if len(metrics) == 0 {
+		return nil
+	}
+	return s.write(s.now(), metrics)
+}
+
+// fresh returns metrics with only the points young enough to keep,
+// by their own timestamps, since they may have waited a while in a
+// retry buffer, or been recorded in the past.  Metrics left with no
+// points are dropped.  The metrics passed in are not changed.
+func (s *Spool) fresh(metrics []*Metric) []*Metric {
+	cutoff := float64(s.now().Add(-s.maxAge).Unix())
+	out := make([]*Metric, 0, len(metrics))
+	for _, m := range metrics {
+		var points [][2]float64
+		for _, p := range m.Value {
+			if p[0] >= cutoff {
+				points = append(points, p)
+			}
+		}
+		if len(points) == 0 {
+			continue
+		}
+		if len(points) != len(m.Value) {
+			young := *m
+			young.Value = points
+			m = &young
+		}
+		out = append(out, m)
+	}
+	return out
+}
+
+// not locked
+func (s *Spool) write(t time.Time, metrics []*Metric) error {
+	out := make([]spooled, 0, len(metrics))
+	for _, m := range metrics {
+		out = append(out, spooled{Metric: m, Unit: m.Unit})
+	}
+	raw, err := json.Marshal(out)
+	if err != nil {
+		return err
+	}
+
+	// write then rename, so a crash never leaves a partial file
+	name := filepath.Join(s.dir, strconv.FormatInt(t.UnixNano(), 10)+spoolExt)
+	tmp, err := ioutil.TempFile(s.dir, "tmp-")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(raw); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), name); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return s.prune()
+}
+
+type spoolFile struct {
+	path    string
+	written time.Time
+	size    int64
+}
+
+// files lists the spool, oldest first
+func (s *Spool) files() ([]spoolFile, error) {
+	infos, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+	var files []spoolFile
+	for _, fi := range infos {
+		name := fi.Name()
+		if fi.IsDir() || !strings.HasSuffix(name, spoolExt) {
+			continue
+		}
+		nanos, err := strconv.ParseInt(strings.TrimSuffix(name, spoolExt), 10, 64)
+		if err != nil {
+			continue
+		}
+		files = append(files, spoolFile{
+			path:    filepath.Join(s.dir, name),
+			written: time.Unix(0, nanos),
+			size:    fi.Size(),
+		})
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].written.Before(files[j].written)
+	})
+	return files, nil
+}
+
+// prune removes expired files, and the oldest files until the spool
+// fits in maxBytes.  Not locked.
+func (s *Spool) prune() error {
+	files, err := s.files()
+	if err != nil {
+		return err
+	}
+	total := int64(0)
+	for _, f := range files {
+		total += f.size
+	}
+	cutoff := s.now().Add(-s.maxAge)
+	for _, f := range files {
+		if !f.written.Before(cutoff) && (s.maxBytes <= 0 || total <= s.maxBytes) {
+			break
+		}
+		if err := os.Remove(f.path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		total -= f.size
+	}
+	return nil
+}
+
+// Replay submits the spooled metrics to sink, oldest first, removing
+// each file once it is sent.  Points too old to send are dropped.  It
+// stops at the first temporary error.  Metrics that fail permanently
+// are dropped, and the first such failure is returned once the rest
+// of the spool is replayed.
+func (s *Spool) Replay(ctx context.Context, sink Sink) error {
+	s.Lock()
+	defer s.Unlock()
+
+	if err := s.prune(); err != nil {
+		return err
+	}
+	files, err := s.files()
+	if err != nil {
+		return err
+	}
+	var dropped error
+	for _, f := range files {
+		metrics, err := readSpool(f.path)
+		if err != nil {
+			// corrupt, can't ever be sent
+			os.Remove(f.path)
+			continue
+		}
+		if metrics = s.fresh(metrics); len(metrics) == 0 {
+			os.Remove(f.path)
+			continue
+		}
+		err = sink.Submit(ctx, metrics)
+		if retry := retryable(err, metrics); len(retry) != 0 {
+			if len(retry) != len(metrics) {
+				// keep only what failed, as of the same time
+				if werr := s.write(f.written, retry); werr != nil {
+					return werr
+				}
+			}
+			return fmt.Errorf("spool replay: %v", err)
+		}
+		if err != nil && dropped == nil {
+			dropped = fmt.Errorf("spool replay: dropped %d metrics: %v", len(FailedMetrics(err, metrics)), err)
+		}
+		if err := os.Remove(f.path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return dropped
+}
+
+func readSpool(path string) ([]*Metric, error) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var in []spooled
+	if err := json.Unmarshal(raw, &in); err != nil {
+		return nil, err
+	}
+	metrics := make([]*Metric, 0, len(in))
+	for _, sm := range in {
+		if sm.Metric == nil {
+			continue
+		}
+		sm.Metric.Unit = sm.Unit
+		metrics = append(metrics, sm.Metric)
+	}
+	return metrics, nil
+}
@@ -0,0 +1,222 @@
+package dogdirect
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestSpool(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	spool, err := NewSpool(dir, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var sent []*Metric
+	var fail error
+	sink := SinkFunc(func(ctx context.Context, metrics []*Metric) error {
+		if fail != nil {
+			return fail
+		}
+		sent = append(sent, metrics...)
+		return nil
+	})
+	c := NewClient(WithHostname("hostname"), WithSink(sink), WithSpool(spool))
+
+	// temporary failure is spooled
+	fail = &StatusError{StatusCode: 503}
+	c.Gauge("first", 1, nil)
+	if err := c.Flush(); err == nil {
+		t.Fatalf("expected flush error")
+	}
+	// permanent failure is not
+	fail = &StatusError{StatusCode: 400}
+	c.Gauge("dropped", 1, nil)
+	if err := c.Flush(); err == nil {
+		t.Fatalf("expected flush error")
+	}
+	if files, _ := spool.files(); len(files) != 1 {
+		t.Fatalf("got %d spool files, want 1", len(files))
+	}
+
+	// next success replays, like after a restart
+	fail = nil
+	c = NewClient(WithHostname("hostname"), WithSink(sink), WithSpool(spool))
+	c.Gauge("second", 2, nil)
+	if err := c.Flush(); err != nil {
+		t.Fatalf("c.Flush(): %v", err)
+	}
+	if len(sent) != 2 || sent[0].Name != "second" || sent[1].Name != "first" {
+		t.Errorf("got sent %v", sent)
+	}
+	if files, _ := spool.files(); len(files) != 0 {
+		t.Errorf("got %d spool files after replay, want 0", len(files))
+	}
+}
+
+func TestSpoolLimits(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	spool, err := NewSpool(dir, 0, 10*time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	spool.now = func() time.Time { return now }
+
+	metric := NewMetric("foo", TypeGauge, nil)
+	metrics := []*Metric{metric}
+	for i := 0; i < 3; i++ {
+		metric.Value = [][2]float64{{float64(now.Unix()), 1}}
+		if err := spool.Write(metrics); err != nil {
+			t.Fatal(err)
+		}
+		now = now.Add(6 * time.Minute)
+	}
+	// the first one expired
+	files, _ := spool.files()
+	if len(files) != 2 {
+		t.Fatalf("got %d spool files, want 2", len(files))
+	}
+
+	// only room for one
+	spool.maxBytes = files[0].size
+	if err := spool.Write(metrics); err != nil {
+		t.Fatal(err)
+	}
+	if files, _ = spool.files(); len(files) != 1 || !files[0].written.Equal(now) {
+		t.Errorf("got spool files %v, want only the newest", files)
+	}
+}
+
+func TestSpoolPointAge(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	spool, err := NewSpool(dir, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2022, 1, 1, 12, 0, 0, 0, time.UTC)
+	spool.now = func() time.Time { return now }
+	at := func(d time.Duration) float64 {
+		return float64(now.Add(d).Unix())
+	}
+
+	// old points are not written, however recently they failed
+	stale := NewMetric("stale", TypeGauge, nil)
+	stale.Value = [][2]float64{{at(-58 * time.Minute), 1}}
+	mixed := NewMetric("mixed", TypeGauge, nil)
+	mixed.Value = [][2]float64{{at(-58 * time.Minute), 1}, {at(-30 * time.Minute), 2}, {at(-time.Minute), 3}}
+	if err := spool.Write([]*Metric{stale, mixed}); err != nil {
+		t.Fatal(err)
+	}
+	if len(mixed.Value) != 3 {
+		t.Errorf("Write changed the points of its metrics")
+	}
+
+	// and points that grew old in the spool are not replayed
+	now = now.Add(40 * time.Minute)
+	var sent []*Metric
+	sink := SinkFunc(func(ctx context.Context, metrics []*Metric) error {
+		sent = append(sent, metrics...)
+		return nil
+	})
+	if err := spool.Replay(context.Background(), sink); err != nil {
+		t.Fatalf("spool.Replay(): %v", err)
+	}
+	if len(sent) != 1 || sent[0].Name != "mixed" || len(sent[0].Value) != 1 || sent[0].Value[0][1] != 3 {
+		t.Errorf("got sent %v", sent)
+	}
+}
+
+func TestSpoolReplayPermanent(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	spool, err := NewSpool(dir, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := NewMetric("foo", TypeGauge, nil)
+	m.Value = [][2]float64{{float64(time.Now().Unix()), 1}}
+	if err := spool.Write([]*Metric{m}); err != nil {
+		t.Fatal(err)
+	}
+
+	// such as a key that was rotated
+	sink := SinkFunc(func(ctx context.Context, metrics []*Metric) error {
+		return &StatusError{StatusCode: 403}
+	})
+	if err := spool.Replay(context.Background(), sink); err == nil {
+		t.Errorf("expected replay error")
+	}
+	if files, _ := spool.files(); len(files) != 0 {
+		t.Errorf("got %d spool files, want 0", len(files))
+	}
+}
+
+func TestSpoolShutdown(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	spool, err := NewSpool(dir, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a network partition: the server never answers
+	hang := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-hang
+	}))
+	defer ts.Close()
+	defer close(hang)
+
+	api := NewAPI("foo", "bar", 0, WithEndpoint(ts.URL))
+	for _, retries := range []bool{false, true} {
+		opts := []Option{WithHostname("hostname"), WithSink(api), WithSpool(spool)}
+		if retries {
+			opts = append(opts, WithRetryBuffer(1<<20))
+		}
+		c := NewClient(opts...)
+		p := NewPeriodic(c, time.Hour)
+		c.Gauge("abandoned", 1, nil)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		if err := p.Shutdown(ctx); err != context.DeadlineExceeded {
+			t.Errorf("got %v, want %v", err, context.DeadlineExceeded)
+		}
+		cancel()
+	}
+	files, _ := spool.files()
+	if len(files) != 2 {
+		t.Fatalf("got %d spool files, want 2", len(files))
+	}
+	metrics, err := readSpool(files[0].path)
+	if err != nil || len(metrics) != 1 || metrics[0].Name != "abandoned" {
+		t.Errorf("got spooled %v, %v", metrics, err)
+	}
+}